@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Protection des routes d'administration: le header "Authorization: Bearer <token>"
+// doit porter ADMIN_TOKEN; sans ADMIN_TOKEN les routes sont désactivées (404)
+func adminAuth() gin.HandlerFunc {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		log.Printf("🔒 Routes d'administration désactivées (ADMIN_TOKEN non défini)")
+	}
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse{
+				Error:   "Routes d'administration désactivées",
+				Code:    404,
+				Success: false,
+			})
+			return
+		}
+		given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "Token d'administration invalide",
+				Code:    401,
+				Success: false,
+			})
+		}
+	}
+}
+
+// Handler d'administration: état des identités upstream
+func AdminIdentitiesHandler(c *gin.Context) {
+	response := gin.H{"identities": identities.Status()}
+	if vqdPool != nil {
+		response["vqd_pool"] = vqdPool.Len()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler d'administration: relecture immédiate du fichier de modèles
+func AdminReloadModelsHandler(c *gin.Context) {
+	if err := models.Reload(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   fmt.Sprintf("Rechargement des modèles impossible: %v", err),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"models": len(models.List())})
+}
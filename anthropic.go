@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Structures de l'API Messages d'Anthropic (/v1/messages)
+type AnthropicRequest struct {
+	Model     string             `json:"model" binding:"required"`
+	System    AnthropicContent   `json:"system,omitempty"`
+	Messages  []AnthropicMessage `json:"messages" binding:"required"`
+	MaxTokens int                `json:"max_tokens" binding:"required"`
+	Stream    bool               `json:"stream,omitempty"`
+
+	StopSequences []string `json:"stop_sequences,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content AnthropicContent `json:"content"`
+}
+
+// Contenu Anthropic: chaîne simple ou liste de blocs, réduit à son texte
+type AnthropicContent string
+
+type AnthropicContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type AnthropicResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   interface{}             `json:"stop_reason"`
+	StopSequence interface{}             `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+func (a *AnthropicContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*a = AnthropicContent(text)
+		return nil
+	}
+
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return fmt.Errorf("contenu invalide: chaîne ou liste de blocs attendue")
+	}
+
+	// Seuls les blocs texte sont transmis à DuckDuckGo
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	*a = AnthropicContent(strings.Join(parts, "\n"))
+	return nil
+}
+
+// Conversion d'une requête Anthropic en messages internes
+func (r *AnthropicRequest) toMessages() []Message {
+	var messages []Message
+	if r.System != "" {
+		messages = append(messages, Message{Role: "system", Content: string(r.System)})
+	}
+	for _, message := range r.Messages {
+		messages = append(messages, Message{Role: message.Role, Content: string(message.Content)})
+	}
+	return messages
+}
+
+// Raison de fin au format Anthropic, à partir de l'issue du stream de la session
+func anthropicStopReason(session Conversation) (string, interface{}) {
+	result := session.LastResult()
+	switch {
+	case result.FinishReason == "length":
+		return "max_tokens", nil
+	case result.StopSequence != "":
+		return "stop_sequence", result.StopSequence
+	default:
+		return "end_turn", nil
+	}
+}
+
+// Réponse d'erreur au format Anthropic
+func anthropicError(c *gin.Context, status int, errType, message string) {
+	c.JSON(status, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
+
+// Statut HTTP et type d'erreur Anthropic correspondant à une erreur de chat ou de stream
+func anthropicErrorStatus(err error) (int, string) {
+	status, _ := errorStatus(err)
+	switch {
+	case status == http.StatusBadRequest:
+		return status, "invalid_request_error"
+	case status == http.StatusTooManyRequests:
+		return status, "rate_limit_error"
+	case status == http.StatusServiceUnavailable:
+		return status, "overloaded_error"
+	}
+	return status, "api_error"
+}
+
+// Handler compatible avec l'API Messages d'Anthropic
+func MessagesHandler(c *gin.Context) {
+	var req AnthropicRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		anthropicError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Requête invalide: %v", err))
+		return
+	}
+
+	// Validation du modèle
+	model, err := validateModel(req.Model)
+	if err != nil {
+		anthropicError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	// Envoyer le message
+	prompt := buildContent(req.toMessages())
+	session, events, err := sendEphemeral(c, model, prompt, StreamOptions{
+		Stop:      req.StopSequences,
+		MaxTokens: req.MaxTokens,
+	})
+	if err != nil {
+		status, errType := anthropicErrorStatus(err)
+		anthropicError(c, status, errType, err.Error())
+		return
+	}
+
+	messageID := generateID("msg_")
+
+	if req.Stream {
+		streamAnthropicMessage(c, session, messageID, prompt, events)
+		return
+	}
+
+	// Lire la réponse complète
+	var completeResponse strings.Builder
+	err = drainStream(events, func(chunk string) {
+		completeResponse.WriteString(chunk)
+	})
+	if err != nil {
+		setRetryAfter(c, err)
+		status, errType := anthropicErrorStatus(err)
+		anthropicError(c, status, errType, fmt.Sprintf("Erreur de stream: %v", err))
+		return
+	}
+
+	answer := completeResponse.String()
+	stopReason, stopSequence := anthropicStopReason(session)
+	setUpstreamMessageHeader(c, session)
+	c.JSON(http.StatusOK, AnthropicResponse{
+		ID:           messageID,
+		Type:         "message",
+		Role:         "assistant",
+		Model:        respondingModel(session),
+		Content:      []AnthropicContentBlock{{Type: "text", Text: answer}},
+		StopReason:   stopReason,
+		StopSequence: stopSequence,
+		Usage: AnthropicUsage{
+			InputTokens:  estimateTokens(prompt),
+			OutputTokens: estimateTokens(answer),
+		},
+	})
+}
+
+// Envoi de la séquence d'événements SSE de l'API Messages
+// (message_start, content_block_*, message_delta, message_stop)
+func streamAnthropicMessage(c *gin.Context, session Conversation, messageID, prompt string, events chan UpstreamEvent) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	writeSSEEvent(c, "message_start", gin.H{
+		"type": "message_start",
+		"message": AnthropicResponse{
+			ID:      messageID,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   string(session.CurrentModel()),
+			Content: []AnthropicContentBlock{},
+			Usage:   AnthropicUsage{InputTokens: estimateTokens(prompt)},
+		},
+	})
+	writeSSEEvent(c, "content_block_start", gin.H{
+		"type":          "content_block_start",
+		"index":         0,
+		"content_block": AnthropicContentBlock{Type: "text", Text: ""},
+	})
+	writeSSEEvent(c, "ping", gin.H{"type": "ping"})
+
+	var answer strings.Builder
+	err := drainStream(events, func(text string) {
+		answer.WriteString(text)
+		writeSSEEvent(c, "content_block_delta", gin.H{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": gin.H{"type": "text_delta", "text": text},
+		})
+	})
+	if err != nil {
+		_, errType := anthropicErrorStatus(err)
+		writeSSEEvent(c, "error", gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    errType,
+				"message": fmt.Sprintf("Erreur de stream: %v", err),
+			},
+		})
+		return
+	}
+
+	stopReason, stopSequence := anthropicStopReason(session)
+	writeSSEEvent(c, "content_block_stop", gin.H{"type": "content_block_stop", "index": 0})
+	writeSSEEvent(c, "message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": stopReason, "stop_sequence": stopSequence},
+		"usage": gin.H{"output_tokens": estimateTokens(answer.String())},
+	})
+	writeSSEEvent(c, "message_stop", gin.H{"type": "message_stop"})
+}
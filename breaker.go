@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// État d'un disjoncteur
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// Disjoncteur d'un endpoint ou d'un modèle upstream: ouvert après threshold échecs
+// consécutifs, il rejette les requêtes pendant cooldown puis laisse passer une
+// requête de sonde (half-open) dont l'issue le referme ou le rouvre
+type CircuitBreaker struct {
+	Name string
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probeAt   time.Time // sonde en cours depuis cette date (half-open)
+	threshold int
+	cooldown  time.Duration
+}
+
+// Requête rejetée par un disjoncteur ouvert
+type CircuitOpenError struct {
+	Name       string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("upstream indisponible (disjoncteur %s ouvert), réessayer dans %s", e.Name, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) class() upstreamErrorClass {
+	return upstreamErrorClass{Status: http.StatusServiceUnavailable, Code: "circuit_open"}
+}
+
+func (e *CircuitOpenError) Retryable() bool {
+	return false
+}
+
+func (e *CircuitOpenError) needsNewVQD() bool {
+	return false
+}
+
+// Autorisation d'une requête; en half-open une seule sonde passe à la fois
+// (une sonde restée sans issue pendant cooldown est remplacée)
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case BreakerOpen:
+		if remaining := b.cooldown - now.Sub(b.openedAt); remaining > 0 {
+			return &CircuitOpenError{Name: b.Name, RetryAfter: remaining}
+		}
+		b.state = BreakerHalfOpen
+		b.probeAt = now
+		log.Printf("⚡ Disjoncteur %s semi-ouvert: requête de sonde", b.Name)
+	case BreakerHalfOpen:
+		if now.Sub(b.probeAt) < b.cooldown {
+			return &CircuitOpenError{Name: b.Name, RetryAfter: b.cooldown - now.Sub(b.probeAt)}
+		}
+		b.probeAt = now
+	}
+	return nil
+}
+
+// Délai restant si le disjoncteur rejette les requêtes, sans consommer de sonde
+func (b *CircuitBreaker) Rejecting() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var since time.Time
+	switch b.state {
+	case BreakerOpen:
+		since = b.openedAt
+	case BreakerHalfOpen:
+		since = b.probeAt
+	default:
+		return 0, false
+	}
+	remaining := b.cooldown - time.Since(since)
+	return remaining, remaining > 0
+}
+
+// Abandon de la sonde en cours sans issue pour ce disjoncteur (requête échouée pour
+// une autre raison): la requête suivante peut sonder sans attendre
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probeAt = time.Time{}
+	}
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		log.Printf("⚡ Disjoncteur %s refermé", b.Name)
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		log.Printf("⚡ Disjoncteur %s ouvert pour %s après %d échecs", b.Name, b.cooldown, b.failures)
+	}
+}
+
+// Ensemble des disjoncteurs, créés à la demande: "status" et "chat" pour les
+// endpoints, "model:<id>" pour chaque modèle
+type BreakerSet struct {
+	mu        sync.Mutex
+	breakers  map[string]*CircuitBreaker
+	threshold int // 0 = désactivé
+	cooldown  time.Duration
+}
+
+var breakers = NewBreakerSet(5, 30*time.Second)
+
+func NewBreakerSet(threshold int, cooldown time.Duration) *BreakerSet {
+	return &BreakerSet{breakers: make(map[string]*CircuitBreaker), threshold: threshold, cooldown: cooldown}
+}
+
+// Configuration depuis les variables d'environnement: BREAKER_THRESHOLD (échecs
+// consécutifs avant ouverture, défaut 5, 0 pour désactiver) et BREAKER_COOLDOWN
+// (durée d'ouverture avant sonde, défaut 30s)
+func configureBreakers() {
+	threshold := 5
+	if value := os.Getenv("BREAKER_THRESHOLD"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			log.Fatalf("❌ BREAKER_THRESHOLD invalide: %s", value)
+		}
+		threshold = n
+	}
+	cooldown := 30 * time.Second
+	if value := os.Getenv("BREAKER_COOLDOWN"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Fatalf("❌ BREAKER_COOLDOWN invalide: %s", value)
+		}
+		cooldown = d
+	}
+
+	breakers = NewBreakerSet(threshold, cooldown)
+	if threshold == 0 {
+		log.Printf("⚡ Disjoncteurs désactivés")
+		return
+	}
+	log.Printf("⚡ Disjoncteurs: ouverture après %d échecs, sonde après %s", threshold, cooldown)
+}
+
+// Disjoncteur d'un nom, nil si les disjoncteurs sont désactivés
+func (s *BreakerSet) Get(name string) *CircuitBreaker {
+	if s.threshold == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, exists := s.breakers[name]
+	if !exists {
+		b = &CircuitBreaker{Name: name, state: BreakerClosed, threshold: s.threshold, cooldown: s.cooldown}
+		s.breakers[name] = b
+	}
+	return b
+}
+
+// Autorisation d'une requête par plusieurs disjoncteurs. Tous sont d'abord vérifiés
+// sans consommer de sonde, pour qu'un disjoncteur semi-ouvert ne cède pas sa sonde
+// à une requête qu'un disjoncteur suivant rejette.
+func (s *BreakerSet) Allow(names ...string) error {
+	if open := s.Rejecting(names...); open != nil {
+		return open
+	}
+	for _, name := range names {
+		if b := s.Get(name); b != nil {
+			if err := b.Allow(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *BreakerSet) Success(names ...string) {
+	for _, name := range names {
+		if b := s.Get(name); b != nil {
+			b.Success()
+		}
+	}
+}
+
+func (s *BreakerSet) Failure(names ...string) {
+	for _, name := range names {
+		if b := s.Get(name); b != nil {
+			b.Failure()
+		}
+	}
+}
+
+func (s *BreakerSet) Release(names ...string) {
+	for _, name := range names {
+		if b := s.Get(name); b != nil {
+			b.Release()
+		}
+	}
+}
+
+// Prise en compte d'un envoi de chat par les disjoncteurs "chat" et du modèle.
+// Seules les pannes de l'endpoint (erreur réseau, réponse 5xx) comptent contre
+// "chat"; un refus lié à l'identité (418, 429, VQD) montre au contraire qu'il
+// répond. Un modèle déclaré indisponible, ou une 5xx, compte contre son disjoncteur.
+func (s *BreakerSet) ReportChat(model Model, err error) {
+	chat, modelName := "chat", modelBreaker(model)
+	if err == nil {
+		s.Success(chat, modelName)
+		return
+	}
+
+	var upstreamErr classifiedError
+	if !errors.As(err, &upstreamErr) {
+		s.Failure(chat)
+		s.Release(modelName)
+		return
+	}
+
+	var httpErr *UpstreamError
+	serverError := errors.As(err, &httpErr) && httpErr.StatusCode >= 500
+	switch {
+	case serverError:
+		s.Failure(chat, modelName)
+	case upstreamErr.class().Code == "upstream_unavailable":
+		s.Success(chat)
+		s.Failure(modelName)
+	default:
+		s.Success(chat)
+		s.Release(modelName)
+	}
+}
+
+// Premier disjoncteur qui rejette les requêtes parmi names, sans consommer de sonde
+func (s *BreakerSet) Rejecting(names ...string) *CircuitOpenError {
+	for _, name := range names {
+		if b := s.Get(name); b != nil {
+			if remaining, rejecting := b.Rejecting(); rejecting {
+				return &CircuitOpenError{Name: name, RetryAfter: remaining}
+			}
+		}
+	}
+	return nil
+}
+
+// État d'un disjoncteur pour le health check
+type BreakerStatus struct {
+	Name       string       `json:"name"`
+	State      BreakerState `json:"state"`
+	Failures   int          `json:"failures"`
+	RetryAfter int          `json:"retry_after,omitempty"` // secondes
+}
+
+func (s *BreakerSet) Status() []BreakerStatus {
+	s.mu.Lock()
+	list := make([]*CircuitBreaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		list = append(list, b)
+	}
+	s.mu.Unlock()
+
+	status := []BreakerStatus{}
+	for _, b := range list {
+		b.mu.Lock()
+		entry := BreakerStatus{Name: b.Name, State: b.state, Failures: b.failures}
+		b.mu.Unlock()
+		if remaining, rejecting := b.Rejecting(); rejecting {
+			entry.RetryAfter = retryAfterSeconds(remaining)
+		}
+		status = append(status, entry)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+func modelBreaker(model Model) string {
+	return "model:" + string(model)
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Rejet immédiat (503 et Retry-After) des requêtes de chat tant que le disjoncteur
+// d'un endpoint upstream (statut ou chat) ou celui du modèle demandé est ouvert,
+// au format d'erreur de chaque API
+func circuitGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodPost || strings.HasPrefix(c.FullPath(), "/v1/admin/") {
+			return
+		}
+
+		names := []string{"status", "chat"}
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.Unmarshal(body, &req)
+		name := req.Model
+		if strings.HasPrefix(c.FullPath(), "/api/") {
+			name, _, _ = strings.Cut(name, ":")
+		}
+		if model, _, err := models.Resolve(name); err == nil {
+			names = append(names, modelBreaker(model))
+		}
+
+		open := breakers.Rejecting(names...)
+		if open == nil {
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(open.RetryAfter)))
+		switch {
+		case c.FullPath() == "/v1/messages":
+			anthropicError(c, http.StatusServiceUnavailable, "overloaded_error", open.Error())
+		case strings.HasPrefix(c.FullPath(), "/api/"):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": open.Error()})
+		default:
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+				Error:   open.Error(),
+				Code:    503,
+				Success: false,
+			})
+		}
+		c.Abort()
+	}
+}
+
+// Header Retry-After d'une réponse en erreur lorsqu'un disjoncteur a rejeté la requête
+func setRetryAfter(c *gin.Context, err error) {
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(open.RetryAfter)))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreakerSetAllowKeepsProbeWhenAnotherBreakerRejects(t *testing.T) {
+	set := NewBreakerSet(1, 50*time.Millisecond)
+	set.Failure("chat")
+	time.Sleep(60 * time.Millisecond)
+	set.Failure("model:x") // ouvert plus tard: rejette encore
+
+	if err := set.Allow("chat", "model:x"); err == nil {
+		t.Fatal("requête autorisée malgré le disjoncteur du modèle ouvert")
+	}
+	// La sonde de "chat" n'a pas été consommée
+	if err := set.Allow("chat"); err != nil {
+		t.Fatalf("sonde de chat perdue: %v", err)
+	}
+}
+
+func TestBreakerSetReleaseFreesProbe(t *testing.T) {
+	set := NewBreakerSet(1, time.Hour)
+	b := set.Get("model:x")
+	b.Failure()
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+
+	if err := set.Allow("model:x"); err != nil {
+		t.Fatalf("sonde refusée: %v", err)
+	}
+	if err := set.Allow("model:x"); err == nil {
+		t.Fatal("seconde sonde autorisée pendant la première")
+	}
+	set.Release("model:x")
+	if err := set.Allow("model:x"); err != nil {
+		t.Fatalf("sonde relâchée non réattribuée: %v", err)
+	}
+}
+
+func TestBreakerSetReportChat(t *testing.T) {
+	cases := []struct {
+		name        string
+		err         error
+		chat, model int // échecs attendus
+	}{
+		{name: "succès", err: nil},
+		{name: "réseau", err: errors.New("connection refused"), chat: 2},
+		{name: "5xx", err: &UpstreamError{StatusCode: http.StatusBadGateway}, chat: 2, model: 2},
+		{name: "418", err: &UpstreamError{StatusCode: http.StatusTeapot}},
+		{name: "429", err: &UpstreamError{StatusCode: http.StatusTooManyRequests}},
+		{name: "modèle indisponible", err: &StreamError{Type: "ERR_SERVICE_UNAVAILABLE", Status: 200}, model: 2},
+	}
+	for _, tc := range cases {
+		set := NewBreakerSet(5, time.Minute)
+		set.ReportChat("x", tc.err)
+		set.ReportChat("x", tc.err)
+		if got := set.Get("chat").failures; got != tc.chat {
+			t.Errorf("%s: %d échecs de chat, %d attendus", tc.name, got, tc.chat)
+		}
+		if got := set.Get(modelBreaker("x")).failures; got != tc.model {
+			t.Errorf("%s: %d échecs du modèle, %d attendus", tc.name, got, tc.model)
+		}
+	}
+}
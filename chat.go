@@ -3,33 +3,45 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Types et structures de base
+// Identifiant d'un modèle du registre (voir models.go)
 type Model string
 
-const (
-	GPT4Mini Model = "gpt-4o-mini"
-	Claude3  Model = "claude-3-haiku-20240307"
-	Llama    Model = "meta-llama/Llama-3.3-70B-Instruct-Turbo"
-	Mixtral  Model = "mistralai/Mistral-Small-24B-Instruct-2501"
-	O4Mini   Model = "o4-mini"
-)
-
-const (
+// Endpoints upstream, modifiables par configuration (voir configureUpstreamURLs)
+var (
 	StatusURL = "https://duckduckgo.com/duckchat/v1/status"
 	ChatURL   = "https://duckduckgo.com/duckchat/v1/chat"
 )
 
+// Surcharge des endpoints depuis les variables d'environnement:
+// DDG_BASE_URL pour les deux, DDG_STATUS_URL et DDG_CHAT_URL individuellement
+func configureUpstreamURLs() {
+	if base := os.Getenv("DDG_BASE_URL"); base != "" {
+		UseUpstream(base)
+	}
+	if statusURL := os.Getenv("DDG_STATUS_URL"); statusURL != "" {
+		StatusURL = statusURL
+	}
+	if chatURL := os.Getenv("DDG_CHAT_URL"); chatURL != "" {
+		ChatURL = chatURL
+	}
+}
+
 // Structures pour l'API
 type Message struct {
 	Content string `json:"content,omitempty"`
@@ -55,30 +67,32 @@ type ChatPayload struct {
 }
 
 type DynamicHeaders struct {
-	FeSignals string
 	FeVersion string
-	VqdHash1  string
 }
 
 // Structure principale du chat
 type ChatSession struct {
-	OldVqd     string
-	NewVqd     string
-	Model      Model
-	Messages   []Message
-	Client     *http.Client
-	CookieJar  *cookiejar.Jar
-	RetryCount int
-	FeSignals  string
-	FeVersion  string
-	VqdHash1   string
+	OldVqd   string
+	NewVqd   string
+	Model    Model
+	Messages []Message
+	Client   *http.Client
+	// Identité upstream (cookies, empreinte, proxy), celle qui a obtenu le token VQD
+	Identity *Identity
+	// Outils de recherche activés par défaut (voir StreamOptions.Tools)
+	Tools ToolChoice
+	// Télémétrie x-fe-signals, générée à chaque requête
+	Signals  *feSignals
+	VqdHash1 string
+
+	// Issue de la dernière réponse lue, écrite par la goroutine de lecture du stream
+	// pendant que les handlers la consultent (voir updateResult et LastResult)
+	Result   StreamResult
+	resultMu sync.Mutex
 }
 
-// Fonction pour obtenir le token VQD
-func GetVQD() string {
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	// Configuration des cookies
+// Jar de cookies initialisé avec les préférences du site
+func newDuckDuckGoJar() *cookiejar.Jar {
 	jar, _ := cookiejar.New(nil)
 	u, _ := url.Parse("https://duckduckgo.com")
 	cookies := []*http.Cookie{
@@ -90,105 +104,148 @@ func GetVQD() string {
 		{Name: "preferredDuckAiModel", Value: "3", Domain: ".duckduckgo.com"},
 	}
 	jar.SetCookies(u, cookies)
-	client.Jar = jar
+	return jar
+}
+
+// Fonction pour obtenir le token VQD
+func GetVQD() string {
+	vqd, _ := fetchVQD(identities.Assign())
+	return vqd
+}
+
+// Récupération d'un token VQD avec l'identité donnée, accompagné du challenge
+// x-vqd-hash-1 s'il y en a un
+func fetchVQD(identity *Identity) (string, string) {
+	if err := breakers.Allow("status"); err != nil {
+		log.Printf("⚡ Récupération du VQD refusée: %v", err)
+		return "", ""
+	}
+	client := identity.client(10 * time.Second)
+	proxy := identity.Proxy
 
 	req, _ := http.NewRequest("GET", StatusURL, nil)
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Accept-Language", "fr-FR,fr;q=0.6")
-	req.Header.Set("Cache-Control", "no-store")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Priority", "u=1, i")
-	req.Header.Set("Referer", "https://duckduckgo.com/")
-	req.Header.Set("Sec-CH-UA", `"Brave";v="137", "Chromium";v="137", "Not/A)Brand";v="24"`)
-	req.Header.Set("Sec-CH-UA-Mobile", "?0")
-	req.Header.Set("Sec-CH-UA-Platform", `"Windows"`)
-	req.Header.Set("Sec-Fetch-Dest", "empty")
-	req.Header.Set("Sec-Fetch-Mode", "cors")
-	req.Header.Set("Sec-Fetch-Site", "same-origin")
-	req.Header.Set("Sec-GPC", "1")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36")
-	req.Header.Set("x-vqd-accept", "1")
+	identity.Fingerprint.apply(req, [][2]string{
+		{"Accept", "*/*"},
+		{"Cache-Control", "no-store"},
+		{"DNT", "1"},
+		{"Priority", "u=1, i"},
+		{"Referer", "https://duckduckgo.com/"},
+		{"Sec-Fetch-Dest", "empty"},
+		{"Sec-Fetch-Mode", "cors"},
+		{"Sec-Fetch-Site", "same-origin"},
+		{"x-vqd-accept", "1"},
+	})
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Erreur lors de la récupération du VQD: %v", err)
-		return ""
+		log.Printf("Erreur lors de la récupération du VQD via %s: %v", proxy, err)
+		proxies.Failure(proxy)
+		identities.Report(identity, outcomeFailure)
+		breakers.Failure("status")
+		return "", ""
 	}
 	defer resp.Body.Close()
-	return resp.Header.Get("x-vqd-4")
+
+	// Seule une 5xx signale une panne de l'endpoint; 418 et 429 visent l'identité
+	if resp.StatusCode >= 500 {
+		breakers.Failure("status")
+		return "", ""
+	}
+	breakers.Success("status")
+	switch resp.StatusCode {
+	case http.StatusTeapot:
+		proxies.Ban(proxy)
+		identities.Report(identity, outcomeChallenge)
+		return "", ""
+	case http.StatusTooManyRequests:
+		proxies.Ban(proxy)
+		identities.Report(identity, outcomeRateLimit)
+		return "", ""
+	}
+	proxies.Success(proxy)
+	return resp.Header.Get("x-vqd-4"), resp.Header.Get("x-vqd-hash-1")
 }
 
-// Extraction des headers dynamiques (valeurs fixes fonctionnelles)
+// Extraction des headers dynamiques (valeurs fixes fonctionnelles; x-fe-signals
+// est généré par session, voir feSignals, et x-vqd-hash-1 calculé pour chaque
+// challenge, voir solveVQDChallenge)
 func GetDynamicHeaders() *DynamicHeaders {
 	return &DynamicHeaders{
-		FeSignals: "eyJzdGFydCI6MTc0OTgyODU3NzE1NiwiZXZlbnRzIjpbeyJuYW1lIjoic3RhcnROZXdDaGF0IiwiZGVsdGEiOjYwfV0sImVuZCI6NTM4MX0=",
 		FeVersion: "serp_20250613_094749_ET-cafd73f97f51c983eb30",
-		VqdHash1:  "eyJzZXJ2ZXJfaGFzaGVzIjpbIm5oWlUrcVZ3d3dzODFPVStDTm4vVkZJcS9DbXBSeGxYY2E5cHpGQ0JVZUk9IiwiajRNNmNBRzRheVFqQ21kWkN0a1IzOFY3eVRpd1gvZ2RmcDFueFhEdlV3cz0iXSwiY2xpZW50X2hhc2hlcyI6WyJpRTNqeXRnSm0xZGJaZlo1bW81M1NmaVAxdXUxeEdzY0F5RnB3V2NVOUtrPSIsInJaRGtaR2h4S0JEL1JuY00xVVNraHZNM3pLdEJzQmlzSlJTWFF4L2QzRFU9Il0sInNpZ25hbHMiOnt9LCJtZXRhIjp7InYiOiIzIiwiY2hhbGxlbmdlX2lkIjoiODU3NjA5YjlmMTg2NThlMWM0MzZhZWI2MGM0MDc1ZjdhYWNmYmI0OTlhY2Y4NTVmNDJkNWRjZmM5MTViNDhiOGg4amJ0IiwidGltZXN0YW1wIjoiMTc0OTgyODU3NjQ5NyIsIm9yaWdpbiI6Imh0dHBzOi8vZHVja2R1Y2tnby5jb20iLCJzdGFjayI6IkVycm9yXG5hdCBiYSAoaHR0cHM6Ly9kdWNrZHVja2dvLmNvbS9kaXN0L3dwbS5jaGF0LmNhZmQ3M2Y5N2Y1MWM5ODNlYjMwLmpzOjE6NzQ4MDMpXG5hdCBhc3luYyBkaXNwYXRjaFNlcnZpY2VJbml0aWFsVlFEIChodHRwczovL2R1Y2tkdWNrZ28uY29tL2Rpc3Qvd3BtLmNoYXQuY2FmZDczZjk3ZjUxYzk4M2ViMzAuanM6MTo5OTUyOSkifX0=",
 	}
 }
 
 // Initialisation d'une nouvelle session de chat
 func NewChatSession(model Model) *ChatSession {
-	vqd := GetVQD()
-	if vqd == "" {
+	token, ok := acquireVQD()
+	if !ok {
 		log.Printf("⚠️ Impossible d'obtenir le token VQD")
 		return nil
 	}
 
-	jar, _ := cookiejar.New(nil)
-	u, _ := url.Parse("https://duckduckgo.com")
-	cookies := []*http.Cookie{
-		{Name: "5", Value: "1", Domain: ".duckduckgo.com"},
-		{Name: "dcm", Value: "3", Domain: ".duckduckgo.com"},
-		{Name: "dcs", Value: "1", Domain: ".duckduckgo.com"},
-		{Name: "duckassist-opt-in-count", Value: "1", Domain: ".duckduckgo.com"},
-		{Name: "isRecentChatOn", Value: "1", Domain: ".duckduckgo.com"},
-		{Name: "preferredDuckAiModel", Value: "3", Domain: ".duckduckgo.com"},
+	session := &ChatSession{
+		OldVqd:   token.Value,
+		NewVqd:   token.Value,
+		Model:    model,
+		Messages: []Message{},
+		Identity: token.Identity,
+		Client:   token.Identity.client(30 * time.Second),
+		Signals:  newFeSignals(),
 	}
-	jar.SetCookies(u, cookies)
+	session.solveChallenge(token.Challenge)
+	return session
+}
 
-	headers := GetDynamicHeaders()
-
-	return &ChatSession{
-		OldVqd:     vqd,
-		NewVqd:     vqd,
-		Model:      model,
-		Messages:   []Message{},
-		CookieJar:  jar,
-		Client:     &http.Client{Timeout: 30 * time.Second, Jar: jar},
-		RetryCount: 0,
-		FeSignals:  headers.FeSignals,
-		FeVersion:  headers.FeVersion,
-		VqdHash1:   headers.VqdHash1,
+// Calcul de x-vqd-hash-1 pour le challenge reçu avec le token VQD. Une réponse ne
+// vaut que pour son challenge: sans challenge ou s'il n'est pas résolu, le header
+// est omis (l'upstream répond alors 418 et le retry repart d'un nouveau token)
+func (c *ChatSession) solveChallenge(challenge string) {
+	c.VqdHash1 = ""
+	if challenge == "" {
+		return
 	}
+	hash, err := solveVQDChallenge(challenge, c.Identity.Fingerprint.UserAgent)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+		return
+	}
+	c.VqdHash1 = hash
 }
 
-// Envoi d'une requête de chat
-func (c *ChatSession) SendMessage(content string) (*http.Response, error) {
-	if c.NewVqd == "" {
-		c.NewVqd = GetVQD()
-		if c.NewVqd == "" {
-			return nil, fmt.Errorf("impossible d'obtenir le token VQD")
-		}
+// Remplacement du token VQD de la session, avec l'identité qui l'accompagne
+func (c *ChatSession) refreshVQD() bool {
+	token, ok := acquireVQD()
+	if !ok {
+		c.NewVqd = ""
+		return false
+	}
+	c.NewVqd = token.Value
+	c.Identity = token.Identity
+	c.Client = token.Identity.client(30 * time.Second)
+	c.solveChallenge(token.Challenge)
+	return true
+}
+
+// Envoi d'une requête de chat, avec retry selon retryPolicy. Le message n'entre
+// dans l'historique qu'une fois accepté par l'upstream. L'annulation du contexte
+// (client déconnecté, arrêt du serveur) interrompt la requête et les retries.
+func (c *ChatSession) SendMessage(ctx context.Context, content string, tools ToolChoice) (*http.Response, error) {
+	c.updateResult(func(result *StreamResult) { *result = StreamResult{} })
+	if c.NewVqd == "" && !c.refreshVQD() {
+		return nil, fmt.Errorf("impossible d'obtenir le token VQD")
 	}
 
-	// Ajouter le message de l'utilisateur
-	c.Messages = append(c.Messages, Message{
+	messages := append(append([]Message{}, c.Messages...), Message{
 		Role:    "user",
 		Content: content,
 	})
 
 	payload := ChatPayload{
-		Model: c.Model,
+		Model: models.UpstreamID(c.Model),
 		Metadata: Metadata{
-			ToolChoice: ToolChoice{
-				NewsSearch:      false,
-				VideosSearch:    false,
-				LocalSearch:     false,
-				WeatherForecast: false,
-			},
+			ToolChoice: tools,
 		},
-		Messages:    c.Messages,
+		Messages:    messages,
 		CanUseTools: true,
 	}
 
@@ -197,34 +254,115 @@ func (c *ChatSession) SendMessage(content string) (*http.Response, error) {
 		return nil, fmt.Errorf("erreur lors de la sérialisation: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", ChatURL, bytes.NewBuffer(jsonPayload))
+	policy := retryPolicy
+	deadline := time.Now().Add(policy.Deadline)
+	circuits := []string{"chat", modelBreaker(c.Model)}
+	for attempt := 1; ; attempt++ {
+		// Disjoncteur ouvert: échec immédiat, sans requête upstream
+		if err := breakers.Allow(circuits...); err != nil {
+			if attempt == 1 {
+				return nil, err
+			}
+			return nil, &RetryError{Attempts: attempt - 1, Err: err}
+		}
+		c.updateResult(func(result *StreamResult) { result.Attempts = attempt })
+
+		resp, err := c.post(ctx, jsonPayload)
+		if err != nil && ctx.Err() != nil {
+			// Requête abandonnée par le client: sans issue pour les disjoncteurs
+			breakers.Release(circuits...)
+		} else {
+			breakers.ReportChat(c.Model, err)
+		}
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("✅ Requête upstream acceptée après %d tentatives", attempt)
+			}
+			proxies.Success(c.Identity.Proxy)
+			identities.Report(c.Identity, outcomeSuccess)
+			c.Messages = messages
+
+			// Mise à jour du token VQD pour les prochaines requêtes
+			if newVqd := resp.Header.Get("x-vqd-4"); newVqd != "" {
+				c.OldVqd = c.NewVqd
+				c.NewVqd = newVqd
+			}
+			c.solveChallenge(resp.Header.Get("x-vqd-hash-1"))
+			return resp, nil
+		}
+
+		// Les erreurs réseau sont passagères; les réponses upstream sont classées
+		var upstreamErr classifiedError
+		retryable := !errors.As(err, &upstreamErr) || upstreamErr.Retryable()
+		if ctx.Err() != nil {
+			return nil, &RetryError{Attempts: attempt, Err: ctx.Err()}
+		}
+
+		if !retryable || attempt >= policy.MaxAttempts {
+			return nil, &RetryError{Attempts: attempt, Err: err}
+		}
+
+		delay := policy.backoff(attempt)
+		if policy.Deadline > 0 && time.Now().Add(delay).After(deadline) {
+			log.Printf("⏱️ Délai de retry dépassé après %d tentatives", attempt)
+			return nil, &RetryError{Attempts: attempt, Err: err}
+		}
+		log.Printf("🔄 Retry automatique (tentative %d/%d dans %s): %v", attempt+1, policy.MaxAttempts, delay.Round(time.Millisecond), err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, &RetryError{Attempts: attempt, Err: ctx.Err()}
+		}
+
+		// Empreinte repérée par l'anti-bot, proxy banni ou injoignable, identité
+		// dégradée: le prochain token sera obtenu avec une autre
+		switch {
+		case upstreamErr == nil:
+			proxies.Failure(c.Identity.Proxy)
+			identities.Report(c.Identity, outcomeFailure)
+		case upstreamErr.class().Code == "upstream_challenge":
+			fingerprints.Flag(c.Identity.Fingerprint)
+			proxies.Ban(c.Identity.Proxy)
+			identities.Report(c.Identity, outcomeChallenge)
+		case upstreamErr.class().Code == "rate_limit_exceeded":
+			proxies.Ban(c.Identity.Proxy)
+			identities.Report(c.Identity, outcomeRateLimit)
+		}
+
+		// Rafraîchissement du token VQD
+		if upstreamErr != nil && upstreamErr.needsNewVQD() && !c.refreshVQD() {
+			return nil, &RetryError{Attempts: attempt, Err: fmt.Errorf("impossible d'obtenir le token VQD")}
+		}
+	}
+}
+
+// Un essai d'envoi; renvoie une *UpstreamError si la réponse n'est pas 200,
+// ou une *StreamError si le stream commence par une erreur déclarée
+func (c *ChatSession) post(ctx context.Context, jsonPayload []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", ChatURL, bytes.NewReader(jsonPayload))
 	if err != nil {
 		return nil, fmt.Errorf("erreur lors de la création de la requête: %v", err)
 	}
 
-	// Configuration des headers (rétro-ingénierie)
-	req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Accept-Language", "fr-FR,fr;q=0.6")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Origin", "https://duckduckgo.com")
-	req.Header.Set("Priority", "u=1, i")
-	req.Header.Set("Referer", "https://duckduckgo.com/")
-	req.Header.Set("Sec-CH-UA", `"Brave";v="137", "Chromium";v="137", "Not/A)Brand";v="24"`)
-	req.Header.Set("Sec-CH-UA-Mobile", "?0")
-	req.Header.Set("Sec-CH-UA-Platform", `"Windows"`)
-	req.Header.Set("Sec-Fetch-Dest", "empty")
-	req.Header.Set("Sec-Fetch-Mode", "cors")
-	req.Header.Set("Sec-Fetch-Site", "same-origin")
-	req.Header.Set("Sec-GPC", "1")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36")
-	req.Header.Set("x-fe-signals", c.FeSignals)
-	req.Header.Set("x-fe-version", c.FeVersion)
-	req.Header.Set("x-vqd-4", c.NewVqd)
-
+	// Configuration des headers (rétro-ingénierie), selon l'empreinte de la session
+	headers := [][2]string{
+		{"Accept", "text/event-stream"},
+		{"Content-Type", "application/json"},
+		{"DNT", "1"},
+		{"Origin", "https://duckduckgo.com"},
+		{"Priority", "u=1, i"},
+		{"Referer", "https://duckduckgo.com/"},
+		{"Sec-Fetch-Dest", "empty"},
+		{"Sec-Fetch-Mode", "cors"},
+		{"Sec-Fetch-Site", "same-origin"},
+		{"x-fe-signals", c.Signals.encode()},
+		{"x-fe-version", c.Identity.FeVersion},
+		{"x-vqd-4", c.NewVqd},
+	}
 	if c.VqdHash1 != "" {
-		req.Header.Set("x-vqd-hash-1", c.VqdHash1)
+		headers = append(headers, [2]string{"x-vqd-hash-1", c.VqdHash1})
 	}
+	c.Identity.Fingerprint.apply(req, headers)
 
 	resp, err := c.Client.Do(req)
 	if err != nil {
@@ -234,47 +372,85 @@ func (c *ChatSession) SendMessage(content string) (*http.Response, error) {
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+
+	if err := peekStreamError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
 
-		// Gestion de l'erreur 418 (Anti-bot) avec retry automatique
-		if resp.StatusCode == 418 || resp.StatusCode == 429 || strings.Contains(string(body), "ERR_INVALID_VQD") {
-			time.Sleep(2 * time.Second)
+// Lecture du début du stream jusqu'au premier contenu: une erreur déclarée avant
+// tout texte fait échouer la requête (et peut donc être retentée). Les lignes lues
+// sont rendues au corps de la réponse pour ProcessStreamResponse.
+func peekStreamError(resp *http.Response) error {
+	reader := bufio.NewReader(resp.Body)
+	var consumed bytes.Buffer
 
-			// Rafraîchissement du token VQD
-			c.NewVqd = GetVQD()
+	for {
+		line, err := reader.ReadString('\n')
+		consumed.WriteString(line)
 
-			// Retry si possible
-			if c.NewVqd != "" && c.RetryCount < 3 {
-				c.RetryCount++
-				log.Printf("🔄 Retry automatique (tentative %d/3)...", c.RetryCount)
-				return c.SendMessage(content)
+		if data := strings.TrimPrefix(strings.TrimSpace(line), "data: "); data != strings.TrimSpace(line) {
+			if data == "[DONE]" {
+				break
+			}
+			var chunk upstreamChunk
+			if json.Unmarshal([]byte(data), &chunk) == nil {
+				if chunk.Action == "error" {
+					return &StreamError{Type: chunk.Type, Status: chunk.Status, Body: data}
+				}
+				if chunk.Message != "" || chunk.Role == "tool" {
+					break
+				}
 			}
 		}
-		return nil, fmt.Errorf("erreur %d: %s. Body: %s", resp.StatusCode, resp.Status, string(body))
-	}
-
-	// Mise à jour du token VQD pour les prochaines requêtes
-	if newVqd := resp.Header.Get("x-vqd-4"); newVqd != "" {
-		c.OldVqd = c.NewVqd
-		c.NewVqd = newVqd
+		if err != nil {
+			break
+		}
 	}
 
-	c.RetryCount = 0
-	return resp, nil
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&consumed, reader), resp.Body}
+	return nil
 }
 
-// Traitement du streaming de réponse
-func (c *ChatSession) ProcessStreamResponse(resp *http.Response) (chan string, chan error) {
-	stream := make(chan string, 100)
-	errChan := make(chan error, 1)
+// Taille maximale d'une ligne du stream upstream: un événement de résultats d'outil
+// (actualités, vidéos) dépasse facilement les 64 Kio par défaut de bufio.Scanner
+const maxStreamLineSize = 16 << 20
+
+// Traitement du streaming de réponse: chaque ligne "data:" devient un événement typé.
+// Le stream est coupé (et la connexion upstream fermée) à la première séquence
+// d'arrêt ou lorsque le budget de tokens est atteint. La lecture s'arrête aussi dès
+// que ctx est annulé, même si plus personne ne lit le canal.
+func (c *ChatSession) ProcessStreamResponse(ctx context.Context, resp *http.Response, opts StreamOptions) chan UpstreamEvent {
+	events := make(chan UpstreamEvent, 100)
 
 	go func() {
 		defer resp.Body.Close()
-		defer close(stream)
-		defer close(errChan)
+		defer close(events)
 
 		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
 		var responseBuffer strings.Builder
+		limiter := newStreamLimiter(opts)
+		decoder := &upstreamDecoder{}
+		stopped := false
+
+		send := func(event UpstreamEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
 
+	read:
 		for scanner.Scan() {
 			line := scanner.Text()
 
@@ -282,27 +458,63 @@ func (c *ChatSession) ProcessStreamResponse(resp *http.Response) (chan string, c
 				break
 			}
 
-			if strings.HasPrefix(line, "data: ") {
-				data := strings.TrimPrefix(line, "data: ")
-				var messageData struct {
-					Message string `json:"message"`
-				}
-				if err := json.Unmarshal([]byte(data), &messageData); err != nil {
-					log.Printf("Erreur unmarshaling: %v", err)
-					continue
-				}
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			decoded, err := decoder.decode(strings.TrimPrefix(line, "data: "))
+			if err != nil {
+				log.Printf("Erreur unmarshaling: %v", err)
+				continue
+			}
 
-				if messageData.Message != "" {
-					stream <- messageData.Message
-					responseBuffer.WriteString(messageData.Message)
+			for _, event := range decoded {
+				switch event.Kind {
+				case EventTextDelta:
+					text, stop := limiter.Push(event.Text)
+					if text != "" {
+						if !send(UpstreamEvent{Kind: EventTextDelta, Text: text}) {
+							return
+						}
+						responseBuffer.WriteString(text)
+					}
+					if stop {
+						stopped = true
+						resp.Body.Close()
+						break read
+					}
+				case EventError:
+					log.Printf("⚠️ Erreur upstream dans le stream: %v", event.Err)
+					send(event)
+					return
+				default:
+					if !send(event) {
+						return
+					}
 				}
 			}
 		}
 
-		if err := scanner.Err(); err != nil {
-			errChan <- fmt.Errorf("erreur lecture stream: %v", err)
-			return
+		if !stopped {
+			if err := scanner.Err(); err != nil {
+				send(UpstreamEvent{Kind: EventError, Err: fmt.Errorf("erreur lecture stream: %v", err)})
+				return
+			}
+
+			// Texte retenu en attente d'une éventuelle séquence d'arrêt
+			if text := limiter.Flush(); text != "" {
+				if !send(UpstreamEvent{Kind: EventTextDelta, Text: text}) {
+					return
+				}
+				responseBuffer.WriteString(text)
+			}
 		}
+		result := limiter.Result()
+		result.Model = decoder.meta.Model
+		result.MessageID = decoder.meta.ID
+		c.updateResult(func(last *StreamResult) {
+			result.Attempts = last.Attempts
+			*last = result
+		})
 
 		// Ajouter la réponse complète à l'historique
 		if responseBuffer.Len() > 0 {
@@ -311,15 +523,61 @@ func (c *ChatSession) ProcessStreamResponse(resp *http.Response) (chan string, c
 				Content: responseBuffer.String(),
 			})
 		}
+
+		send(UpstreamEvent{Kind: EventDone, Result: result})
 	}()
 
-	return stream, errChan
+	return events
+}
+
+// Envoi d'un message et lecture de sa réponse (implémentation de Conversation)
+func (c *ChatSession) Send(ctx context.Context, content string, opts StreamOptions) (chan UpstreamEvent, error) {
+	tools := c.Tools
+	if opts.Tools != nil {
+		tools = *opts.Tools
+	}
+	resp, err := c.SendMessage(ctx, content, tools)
+	if err != nil {
+		return nil, err
+	}
+	return c.ProcessStreamResponse(ctx, resp, opts), nil
+}
+
+func (c *ChatSession) CurrentModel() Model {
+	return c.Model
+}
+
+func (c *ChatSession) SetModel(model Model) {
+	if model != c.Model {
+		c.Signals.record("initSwitchModel")
+	}
+	c.Model = model
+}
+
+func (c *ChatSession) CurrentTools() ToolChoice {
+	return c.Tools
+}
+
+func (c *ChatSession) SetTools(tools ToolChoice) {
+	c.Tools = tools
+}
+
+func (c *ChatSession) LastResult() StreamResult {
+	c.resultMu.Lock()
+	defer c.resultMu.Unlock()
+	return c.Result
+}
+
+func (c *ChatSession) updateResult(update func(result *StreamResult)) {
+	c.resultMu.Lock()
+	defer c.resultMu.Unlock()
+	update(&c.Result)
 }
 
 // Nettoyage de la session
 func (c *ChatSession) Clear() {
 	c.Messages = []Message{}
-	c.NewVqd = GetVQD()
+	c.refreshVQD()
 	c.OldVqd = c.NewVqd
-	c.RetryCount = 0
+	c.Signals = newFeSignals()
 }
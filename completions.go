@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Structures de l'API historique de complétion de texte (/v1/completions)
+type CompletionRequest struct {
+	Model  string           `json:"model,omitempty"`
+	Prompt CompletionPrompt `json:"prompt" binding:"required"`
+	Suffix string           `json:"suffix,omitempty"`
+	Echo   bool             `json:"echo,omitempty"`
+	Stream bool             `json:"stream,omitempty"`
+
+	Stop      StopSequences `json:"stop,omitempty"`
+	MaxTokens int           `json:"max_tokens,omitempty"`
+}
+
+// Prompt OpenAI: chaîne simple ou liste de chaînes (une choice par prompt)
+type CompletionPrompt []string
+
+type CompletionChoice struct {
+	Text         string      `json:"text"`
+	Index        int         `json:"index"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason interface{} `json:"finish_reason"`
+}
+
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+}
+
+func (p *CompletionPrompt) UnmarshalJSON(data []byte) error {
+	var prompt string
+	if err := json.Unmarshal(data, &prompt); err == nil {
+		*p = CompletionPrompt{prompt}
+		return nil
+	}
+
+	var prompts []string
+	if err := json.Unmarshal(data, &prompts); err != nil {
+		return fmt.Errorf("prompt invalide: chaîne ou liste de chaînes attendue")
+	}
+	*p = CompletionPrompt(prompts)
+	return nil
+}
+
+// Construction du message envoyé à DuckDuckGo pour un prompt (et un suffixe éventuel)
+func completionContent(prompt, suffix string) string {
+	if suffix == "" {
+		return prompt
+	}
+	return fmt.Sprintf("Write only the text that belongs between the following prefix and suffix, without repeating them.\n\nPrefix:\n%s\n\nSuffix:\n%s", prompt, suffix)
+}
+
+// Handler pour la complétion de texte (format text_completion)
+func CompletionsHandler(c *gin.Context) {
+	var req CompletionRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   fmt.Sprintf("Requête invalide: %v", err),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+
+	// Validation du modèle
+	model, err := validateModel(req.Model)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   err.Error(),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+
+	response := CompletionResponse{
+		ID:      generateID("cmpl-"),
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   string(model),
+	}
+
+	if req.Stream {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+	}
+
+	// Chaque prompt est un échange indépendant sur une nouvelle session
+	var usage Usage
+	var sessions []Conversation
+	for index, prompt := range req.Prompt {
+		content := completionContent(prompt, req.Suffix)
+
+		onChunk := func(text string) {}
+		if req.Stream {
+			onChunk = func(text string) {
+				writeSSEData(c, CompletionResponse{
+					ID:      response.ID,
+					Object:  response.Object,
+					Created: response.Created,
+					Model:   response.Model,
+					Choices: []CompletionChoice{{Text: text, Index: index}},
+				})
+			}
+			if req.Echo {
+				onChunk(prompt)
+			}
+		}
+
+		session, err := upstream.NewConversation(model)
+		answer := ""
+		if err == nil {
+			sessions = append(sessions, session)
+			answer, err = exchange(c.Request.Context(), session, content, StreamOptions{Stop: req.Stop, MaxTokens: req.MaxTokens}, onChunk)
+		}
+		if err != nil {
+			status, code := errorStatus(err)
+			if req.Stream {
+				writeSSEData(c, gin.H{
+					"error": gin.H{
+						"message": fmt.Sprintf("Erreur de chat: %v", err),
+						"type":    code,
+					},
+				})
+				return
+			}
+			setAttemptsHeader(c, sessions...)
+			setRetryAfter(c, err)
+			c.JSON(status, ErrorResponse{
+				Error:   fmt.Sprintf("Erreur de chat: %v", err),
+				Code:    status,
+				Success: false,
+			})
+			return
+		}
+
+		// Seul le texte généré compte dans completion_tokens, pas le prompt renvoyé en écho
+		usage.PromptTokens += estimateTokens(content)
+		usage.CompletionTokens += estimateTokens(answer)
+
+		if req.Echo {
+			answer = prompt + answer
+		}
+
+		if req.Stream {
+			writeSSEData(c, CompletionResponse{
+				ID:      response.ID,
+				Object:  response.Object,
+				Created: response.Created,
+				Model:   response.Model,
+				Choices: []CompletionChoice{{Text: "", Index: index, FinishReason: session.LastResult().FinishReason}},
+			})
+			continue
+		}
+
+		response.Choices = append(response.Choices, CompletionChoice{
+			Text:         answer,
+			Index:        index,
+			FinishReason: session.LastResult().FinishReason,
+		})
+	}
+
+	if req.Stream {
+		fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+		c.Writer.Flush()
+		return
+	}
+
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	response.Usage = &usage
+	setAttemptsHeader(c, sessions...)
+	c.JSON(http.StatusOK, response)
+}
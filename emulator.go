@@ -0,0 +1,424 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Anomalies pouvant être injectées dans l'émulateur, consommées une par requête de chat
+type EmulatorFault string
+
+const (
+	FaultAntiBot    EmulatorFault = "418"         // 418 anti-bot
+	FaultRateLimit  EmulatorFault = "429"         // 429 trop de requêtes
+	FaultInvalidVQD EmulatorFault = "invalid-vqd" // corps ERR_INVALID_VQD
+
+	// Erreurs déclarées dans un stream 200
+	FaultConversationLimit EmulatorFault = "conversation-limit" // ERR_CONVERSATION_LIMIT d'entrée
+	FaultStreamRateLimit   EmulatorFault = "stream-429"         // ERR_RATE_LIMIT d'entrée
+	FaultMidStream         EmulatorFault = "mid-stream-error"   // ERR_SERVICE_UNAVAILABLE après un premier chunk
+)
+
+var emulatorFaults = []EmulatorFault{FaultAntiBot, FaultRateLimit, FaultInvalidVQD, FaultConversationLimit, FaultStreamRateLimit, FaultMidStream}
+
+// Anomalie correspondant à un nom; un nom inconnu (faute de frappe) est refusé
+// plutôt que de produire une réponse vide
+func parseEmulatorFault(name string) (EmulatorFault, error) {
+	for _, fault := range emulatorFaults {
+		if string(fault) == name {
+			return fault, nil
+		}
+	}
+	names := make([]string, len(emulatorFaults))
+	for i, fault := range emulatorFaults {
+		names[i] = string(fault)
+	}
+	return "", fmt.Errorf("anomalie inconnue: %q (valeurs possibles: %s)", name, strings.Join(names, ", "))
+}
+
+// Erreur à émettre dans le stream pour une anomalie (nil si elle n'en produit pas)
+func (f EmulatorFault) streamError() map[string]interface{} {
+	switch f {
+	case FaultConversationLimit:
+		return map[string]interface{}{"action": "error", "status": 400, "type": "ERR_CONVERSATION_LIMIT"}
+	case FaultStreamRateLimit:
+		return map[string]interface{}{"action": "error", "status": 429, "type": "ERR_RATE_LIMIT"}
+	case FaultMidStream:
+		return map[string]interface{}{"action": "error", "status": 500, "type": "ERR_SERVICE_UNAVAILABLE"}
+	}
+	return nil
+}
+
+// Émulateur de l'upstream DuckDuckGo pour les tests hors ligne: délivrance de
+// tokens x-vqd-4 par l'endpoint de statut, rotation du token à chaque réponse,
+// streaming "data:" terminé par [DONE], résultats des outils de recherche demandés
+// et injection d'erreurs
+type UpstreamEmulator struct {
+	mu      sync.Mutex
+	counter int
+	vqds    map[string]bool // tokens délivrés et encore utilisables
+	faults  []EmulatorFault
+	replies [][]string
+
+	// Challenges x-vqd-hash-1 délivrés et encore utilisables, par challenge_id
+	challenges map[string]emulatedChallenge
+
+	// Délai entre deux chunks du stream
+	ChunkDelay time.Duration
+	// Délivrance d'un challenge avec chaque token et vérification de x-vqd-hash-1
+	// (418 ERR_CHALLENGE si la réponse est absente ou fausse)
+	Challenges bool
+
+	StatusCalls int
+	ChatCalls   int
+}
+
+func NewUpstreamEmulator() *UpstreamEmulator {
+	return &UpstreamEmulator{vqds: make(map[string]bool), challenges: make(map[string]emulatedChallenge)}
+}
+
+// Challenge délivré: hashes serveur et fragment HTML dont le client doit hacher
+// la longueur (plus le décalage)
+type emulatedChallenge struct {
+	ServerHashes []string
+	HTML         string
+	Offset       int
+}
+
+// Nouveau challenge, sous la forme du script renvoyé par l'upstream
+// (à appeler avec le mutex verrouillé)
+func (e *UpstreamEmulator) issueChallenge() string {
+	id := generateID("")
+	challenge := emulatedChallenge{
+		ServerHashes: []string{randomHash(), randomHash()},
+		HTML:         strings.Repeat("<li><div></div></li>", 1+rand.Intn(4)),
+		Offset:       rand.Intn(0x4000),
+	}
+	e.challenges[id] = challenge
+
+	script := fmt.Sprintf(`(function(){return {'server_hashes':['%s','%s'],'client_hashes':[navigator.userAgent,String(0x%x+(function(){const e=document.createElement('div');e.innerHTML='%s';return e.innerHTML.length})())],'signals':{},'meta':{'v':'4','challenge_id':'%s','timestamp':'%d'}}})()`,
+		challenge.ServerHashes[0], challenge.ServerHashes[1], challenge.Offset, challenge.HTML, id, time.Now().UnixMilli())
+	return base64.StdEncoding.EncodeToString([]byte(script))
+}
+
+// Vérification du header x-vqd-hash-1 d'une requête de chat; chaque challenge
+// n'est valable qu'une fois (à appeler avec le mutex verrouillé)
+func (e *UpstreamEmulator) checkChallenge(header, userAgent string) bool {
+	data, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return false
+	}
+	var answer struct {
+		ServerHashes []string `json:"server_hashes"`
+		ClientHashes []string `json:"client_hashes"`
+		Meta         struct {
+			ChallengeID string `json:"challenge_id"`
+		} `json:"meta"`
+	}
+	if json.Unmarshal(data, &answer) != nil {
+		return false
+	}
+	challenge, exists := e.challenges[answer.Meta.ChallengeID]
+	delete(e.challenges, answer.Meta.ChallengeID)
+	if !exists {
+		return false
+	}
+
+	expected := []string{userAgent, fmt.Sprint(challenge.Offset + len(challenge.HTML))}
+	if len(answer.ClientHashes) != len(expected) || strings.Join(answer.ServerHashes, ",") != strings.Join(challenge.ServerHashes, ",") {
+		return false
+	}
+	for i, value := range expected {
+		sum := sha256.Sum256([]byte(value))
+		if answer.ClientHashes[i] != base64.StdEncoding.EncodeToString(sum[:]) {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHash() string {
+	sum := sha256.Sum256([]byte(generateID("")))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Ajout d'anomalies à la file (une par requête de chat); aucune n'est ajoutée si
+// l'une d'elles est inconnue
+func (e *UpstreamEmulator) InjectFaults(faults ...EmulatorFault) error {
+	for _, fault := range faults {
+		if _, err := parseEmulatorFault(string(fault)); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.faults = append(e.faults, faults...)
+	return nil
+}
+
+// Ajout d'une réponse scriptée (à défaut, le dernier message est renvoyé en écho)
+func (e *UpstreamEmulator) ScriptReply(chunks ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.replies = append(e.replies, chunks)
+}
+
+// Démarrage sur un port local aléatoire; renvoie l'URL de base et la fonction d'arrêt
+func (e *UpstreamEmulator) Start() (string, func()) {
+	server := httptest.NewServer(e.Handler())
+	return server.URL, server.Close
+}
+
+func (e *UpstreamEmulator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/duckchat/v1/status", e.handleStatus)
+	mux.HandleFunc("/duckchat/v1/chat", e.handleChat)
+	return mux
+}
+
+// Nouveau token VQD (à appeler avec le mutex verrouillé)
+func (e *UpstreamEmulator) issueVQD() string {
+	e.counter++
+	vqd := fmt.Sprintf("4-emulated-%d", e.counter)
+	e.vqds[vqd] = true
+	return vqd
+}
+
+func (e *UpstreamEmulator) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	e.mu.Lock()
+	e.StatusCalls++
+	vqd, challenge := "", ""
+	if r.Header.Get("x-vqd-accept") == "1" {
+		vqd = e.issueVQD()
+		if e.Challenges {
+			challenge = e.issueChallenge()
+		}
+	}
+	e.mu.Unlock()
+
+	if vqd != "" {
+		w.Header().Set("x-vqd-4", vqd)
+	}
+	if challenge != "" {
+		w.Header().Set("x-vqd-hash-1", challenge)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"0"}`))
+}
+
+func (e *UpstreamEmulator) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload ChatPayload
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeEmulatorError(w, http.StatusBadRequest, "ERR_BAD_REQUEST")
+		return
+	}
+
+	e.mu.Lock()
+	e.ChatCalls++
+	var fault EmulatorFault
+	if len(e.faults) > 0 {
+		fault = e.faults[0]
+		e.faults = e.faults[1:]
+	}
+
+	// Chaque token n'est valable que pour une requête
+	vqd := r.Header.Get("x-vqd-4")
+	valid := e.vqds[vqd]
+	delete(e.vqds, vqd)
+	if fault == FaultInvalidVQD {
+		valid = false
+	}
+	solved := !e.Challenges || e.checkChallenge(r.Header.Get("x-vqd-hash-1"), r.Header.Get("User-Agent"))
+
+	var chunks []string
+	nextVQD := ""
+	streamErr := fault.streamError()
+	nextChallenge := ""
+	if valid && solved && (fault == "" || streamErr != nil) {
+		nextVQD = e.issueVQD()
+		if e.Challenges {
+			nextChallenge = e.issueChallenge()
+		}
+	}
+	if nextVQD != "" && (fault == "" || fault == FaultMidStream) {
+		if len(e.replies) > 0 {
+			chunks = e.replies[0]
+			e.replies = e.replies[1:]
+		} else {
+			chunks = echoChunks(payload.Messages)
+		}
+	}
+	e.mu.Unlock()
+
+	switch {
+	case fault == FaultAntiBot || !solved:
+		writeEmulatorError(w, http.StatusTeapot, "ERR_CHALLENGE")
+		return
+	case fault == FaultRateLimit:
+		writeEmulatorError(w, http.StatusTooManyRequests, "ERR_RATE_LIMIT")
+		return
+	case !valid:
+		writeEmulatorError(w, http.StatusBadRequest, "ERR_INVALID_VQD")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("x-vqd-4", nextVQD)
+	if nextChallenge != "" {
+		w.Header().Set("x-vqd-hash-1", nextChallenge)
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	writeEvent := func(event interface{}) {
+		data, _ := json.Marshal(event)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	switch fault {
+	case FaultConversationLimit, FaultStreamRateLimit:
+		writeEvent(streamErr)
+		return
+	case FaultMidStream:
+		// Erreur après le premier chunk, ou d'entrée pour une réponse scriptée vide
+		if len(chunks) > 1 {
+			chunks = chunks[:1]
+		}
+	}
+
+	id := generateID("emulated-")
+	for _, tool := range enabledTools(payload.Metadata.ToolChoice) {
+		query := ""
+		if len(payload.Messages) > 0 {
+			query = payload.Messages[len(payload.Messages)-1].Content
+		}
+		writeEvent(map[string]interface{}{
+			"role":    "tool",
+			"name":    tool,
+			"created": time.Now().Unix(),
+			"id":      id,
+			"action":  "success",
+			"model":   payload.Model,
+			"results": []map[string]string{{
+				"title": fmt.Sprintf("%s: %s", tool, query),
+				"url":   "https://duckduckgo.com/?q=" + url.QueryEscape(query),
+			}},
+		})
+	}
+	for _, chunk := range chunks {
+		writeEvent(map[string]interface{}{
+			"role":    "assistant",
+			"message": chunk,
+			"created": time.Now().Unix(),
+			"id":      id,
+			"action":  "success",
+			"model":   payload.Model,
+		})
+		if e.ChunkDelay > 0 {
+			time.Sleep(e.ChunkDelay)
+		}
+	}
+	if fault == FaultMidStream {
+		writeEvent(streamErr)
+		return
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// Outils de recherche activés dans la requête, sous leur nom upstream
+func enabledTools(choice ToolChoice) []string {
+	var tools []string
+	if choice.NewsSearch {
+		tools = append(tools, "NewsSearch")
+	}
+	if choice.VideosSearch {
+		tools = append(tools, "VideosSearch")
+	}
+	if choice.LocalSearch {
+		tools = append(tools, "LocalSearch")
+	}
+	if choice.WeatherForecast {
+		tools = append(tools, "WeatherForecast")
+	}
+	return tools
+}
+
+// Réponse par défaut: écho du dernier message, mot par mot
+func echoChunks(messages []Message) []string {
+	if len(messages) == 0 {
+		return []string{""}
+	}
+	words := strings.SplitAfter(messages[len(messages)-1].Content, " ")
+	return append([]string{"echo: "}, words...)
+}
+
+func writeEmulatorError(w http.ResponseWriter, status int, errType string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"action":"error","status":%d,"type":"%s"}`, status, errType)
+}
+
+// Redirection des appels upstream vers une URL de base (émulateur, proxy de test)
+func UseUpstream(baseURL string) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	StatusURL = baseURL + "/duckchat/v1/status"
+	ChatURL = baseURL + "/duckchat/v1/chat"
+}
+
+// Sous-commande "mock-upstream": lancement de l'émulateur en serveur autonome
+func runMockUpstream(args []string) {
+	flags := flag.NewFlagSet("mock-upstream", flag.ExitOnError)
+	port := flags.String("port", "8081", "port d'écoute")
+	faults := flags.String("faults", "", "anomalies initiales, séparées par des virgules (418, 429, invalid-vqd, conversation-limit, stream-429, mid-stream-error)")
+	delay := flags.Duration("chunk-delay", 0, "délai entre deux chunks")
+	challenges := flags.Bool("challenge", false, "délivrer des challenges x-vqd-hash-1 et vérifier les réponses")
+	flags.Parse(args)
+
+	emulator := NewUpstreamEmulator()
+	emulator.ChunkDelay = *delay
+	emulator.Challenges = *challenges
+	for _, name := range strings.Split(*faults, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		fault, err := parseEmulatorFault(name)
+		if err != nil {
+			log.Fatalf("❌ -faults: %v", err)
+		}
+		emulator.InjectFaults(fault)
+	}
+
+	log.Printf("🦆 Émulateur DuckDuckGo démarré sur le port %s", *port)
+	log.Printf("📋 Utilisation: DDG_BASE_URL=http://localhost:%s", *port)
+	if err := http.ListenAndServe(":"+*port, emulator.Handler()); err != nil {
+		log.Printf("❌ Erreur de démarrage de l'émulateur: %v", err)
+		os.Exit(1)
+	}
+}
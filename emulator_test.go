@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Émulateur démarré pour la durée du test, avec les URLs upstream redirigées vers
+// lui, des retries sans attente et des empreintes, identités et disjoncteurs neufs
+func startEmulator(t *testing.T, challenges bool) *UpstreamEmulator {
+	t.Helper()
+	emulator := NewUpstreamEmulator()
+	emulator.Challenges = challenges
+	baseURL, stop := emulator.Start()
+
+	statusURL, chatURL, policy := StatusURL, ChatURL, retryPolicy
+	pool, manager, set := fingerprints, identities, breakers
+	UseUpstream(baseURL)
+	retryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Deadline: 5 * time.Second}
+	fingerprints = NewFingerprintPool(defaultFingerprints, 10*time.Minute)
+	identities = NewIdentityManager(8, 10*time.Minute, 0.5)
+	breakers = NewBreakerSet(5, 30*time.Second)
+	t.Cleanup(func() {
+		stop()
+		StatusURL, ChatURL, retryPolicy = statusURL, chatURL, policy
+		fingerprints, identities, breakers = pool, manager, set
+	})
+	return emulator
+}
+
+// Conversation DuckDuckGo ouverte sur l'émulateur
+func newEmulatedSession(t *testing.T) *ChatSession {
+	t.Helper()
+	conversation, err := (&DuckDuckGoProvider{}).NewConversation(models.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conversation.(*ChatSession)
+}
+
+// Envoi d'un message et lecture de la réponse complète
+func sendAndCollect(t *testing.T, session *ChatSession, content string, opts StreamOptions) (string, []UpstreamEvent) {
+	t.Helper()
+	events, err := session.Send(context.Background(), content, opts)
+	if err != nil {
+		t.Fatalf("envoi refusé: %v", err)
+	}
+	var text strings.Builder
+	var received []UpstreamEvent
+	if err := drainEvents(events, func(event UpstreamEvent) {
+		received = append(received, event)
+		if event.Kind == EventTextDelta {
+			text.WriteString(event.Text)
+		}
+	}); err != nil {
+		t.Fatalf("stream en erreur: %v", err)
+	}
+	return text.String(), received
+}
+
+func TestEmulatorIssuesAndRotatesVQD(t *testing.T) {
+	emulator := startEmulator(t, false)
+
+	session := newEmulatedSession(t)
+	if emulator.StatusCalls != 1 || !strings.HasPrefix(session.NewVqd, "4-emulated-") {
+		t.Fatalf("token %q après %d appels de statut", session.NewVqd, emulator.StatusCalls)
+	}
+	first := session.NewVqd
+
+	text, _ := sendAndCollect(t, session, "premier message", StreamOptions{})
+	if text != "echo: premier message" {
+		t.Errorf("réponse %q", text)
+	}
+	// Le token renvoyé avec la réponse remplace le précédent, sans nouvel appel de statut
+	if session.OldVqd != first || session.NewVqd == first || emulator.StatusCalls != 1 {
+		t.Errorf("token non renouvelé: old=%q new=%q statut=%d", session.OldVqd, session.NewVqd, emulator.StatusCalls)
+	}
+	sendAndCollect(t, session, "second message", StreamOptions{})
+	// Deux échanges: chaque message suivi de la réponse de l'assistant
+	if len(session.Messages) != 4 || session.Messages[1].Content != "echo: premier message" {
+		t.Errorf("historique %+v", session.Messages)
+	}
+}
+
+func TestEmulatorHashChallenge(t *testing.T) {
+	emulator := startEmulator(t, true)
+
+	session := newEmulatedSession(t)
+	// Deux envois: le challenge délivré avec le token puis celui renvoyé avec la réponse
+	for _, content := range []string{"un", "deux"} {
+		text, _ := sendAndCollect(t, session, content, StreamOptions{})
+		if text != "echo: "+content {
+			t.Fatalf("réponse %q", text)
+		}
+	}
+	if emulator.ChatCalls != 2 {
+		t.Errorf("%d requêtes de chat pour 2 envois (challenge refusé?)", emulator.ChatCalls)
+	}
+	if result := session.LastResult(); result.Attempts != 1 {
+		t.Errorf("%d tentatives", result.Attempts)
+	}
+}
+
+func TestEmulatorRetriesAfterChallengeRejection(t *testing.T) {
+	emulator := startEmulator(t, false)
+	emulator.InjectFaults(FaultAntiBot)
+
+	session := newEmulatedSession(t)
+	text, _ := sendAndCollect(t, session, "après un 418", StreamOptions{})
+	if text != "echo: après un 418" {
+		t.Errorf("réponse %q", text)
+	}
+	if attempts := session.LastResult().Attempts; attempts != 2 || emulator.ChatCalls != 2 {
+		t.Errorf("%d tentatives, %d requêtes de chat", attempts, emulator.ChatCalls)
+	}
+	// Le token consommé par la requête refusée est remplacé par un nouveau
+	if emulator.StatusCalls != 2 {
+		t.Errorf("%d appels de statut", emulator.StatusCalls)
+	}
+}
+
+func TestEmulatorRetriesExhausted(t *testing.T) {
+	emulator := startEmulator(t, false)
+	emulator.InjectFaults(FaultAntiBot, FaultAntiBot, FaultAntiBot)
+
+	session := newEmulatedSession(t)
+	_, err := session.Send(context.Background(), "bloqué", StreamOptions{})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 3 {
+		t.Fatalf("erreur %v", err)
+	}
+	if status, code := errorStatus(err); status != 503 || code != "upstream_challenge" {
+		t.Errorf("statut %d (%s)", status, code)
+	}
+	if len(session.Messages) != 0 {
+		t.Error("message refusé ajouté à l'historique")
+	}
+}
+
+func TestEmulatorStreamEvents(t *testing.T) {
+	emulator := startEmulator(t, false)
+	emulator.ScriptReply("Il fait ", "beau.")
+
+	session := newEmulatedSession(t)
+	tools := ToolChoice{WeatherForecast: true}
+	text, events := sendAndCollect(t, session, "météo", StreamOptions{Tools: &tools})
+	if text != "Il fait beau." {
+		t.Errorf("réponse %q", text)
+	}
+
+	var kinds []string
+	for _, event := range events {
+		kinds = append(kinds, string(event.Kind))
+		if event.Kind == EventToolOutput && (event.Tool.Name != "weather" || len(event.Tool.Results) != 1) {
+			t.Errorf("résultat d'outil %+v", event.Tool)
+		}
+	}
+	if got := strings.Join(kinds, ","); !strings.Contains(got, "tool_output,text_delta,text_delta") || !strings.HasSuffix(got, "done") {
+		t.Errorf("événements %s", got)
+	}
+	if result := events[len(events)-1].Result; result.FinishReason != "stop" || result.Model == "" {
+		t.Errorf("issue %+v", result)
+	}
+}
+
+func TestEmulatorMidStreamError(t *testing.T) {
+	emulator := startEmulator(t, false)
+	emulator.InjectFaults(FaultMidStream)
+
+	session := newEmulatedSession(t)
+	events, err := session.Send(context.Background(), "coupé en route", StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	err = drainStream(events, func(chunk string) { text.WriteString(chunk) })
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || streamErr.Type != "ERR_SERVICE_UNAVAILABLE" {
+		t.Fatalf("erreur %v", err)
+	}
+	if text.String() != "echo: " {
+		t.Errorf("texte reçu avant l'erreur %q", text.String())
+	}
+}
+
+func TestEmulatorRejectsUnknownFaults(t *testing.T) {
+	emulator := NewUpstreamEmulator()
+	if err := emulator.InjectFaults(FaultAntiBot, EmulatorFault("481")); err == nil {
+		t.Fatal("anomalie 481 acceptée")
+	}
+	if len(emulator.faults) != 0 {
+		t.Errorf("anomalies ajoutées malgré l'erreur: %v", emulator.faults)
+	}
+	if fault, err := parseEmulatorFault("mid-stream-error"); err != nil || fault != FaultMidStream {
+		t.Errorf("%q, %v", fault, err)
+	}
+}
+
+// Erreur en cours de stream sur une réponse scriptée vide: l'erreur est émise d'entrée,
+// donc vue avant tout texte et réessayée
+func TestEmulatorMidStreamErrorOnEmptyReply(t *testing.T) {
+	emulator := startEmulator(t, false)
+	emulator.ScriptReply()
+	emulator.InjectFaults(FaultMidStream)
+
+	session := newEmulatedSession(t)
+	text, _ := sendAndCollect(t, session, "vide", StreamOptions{})
+	if text != "echo: vide" || session.LastResult().Attempts < 2 {
+		t.Errorf("réponse %q après %d tentatives", text, session.LastResult().Attempts)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Nature d'un événement du stream upstream
+type UpstreamEventKind string
+
+const (
+	EventTextDelta  UpstreamEventKind = "text_delta"
+	EventMetadata   UpstreamEventKind = "metadata"
+	EventToolOutput UpstreamEventKind = "tool_output"
+	EventError      UpstreamEventKind = "error"
+	EventDone       UpstreamEventKind = "done"
+)
+
+// Événement typé d'une réponse upstream. Le flux se termine par EventDone
+// (issue de la réponse) ou EventError; un flux fermé sans l'un des deux a été interrompu.
+type UpstreamEvent struct {
+	Kind     UpstreamEventKind
+	Text     string            // EventTextDelta
+	Metadata *UpstreamMetadata // EventMetadata
+	Tool     *ToolOutput       // EventToolOutput
+	Err      error             // EventError
+	Result   StreamResult      // EventDone
+}
+
+// Identité de la réponse annoncée par l'upstream
+type UpstreamMetadata struct {
+	ID      string
+	Model   string
+	Role    string
+	Created int64
+}
+
+// Résultat d'un outil exécuté par l'upstream (recherche, météo, ...), normalisé pour
+// ne pas exposer le format de l'upstream (voir normalizeToolOutput)
+type ToolOutput struct {
+	Name    string       `json:"name"` // news, videos, local ou weather
+	Results []ToolResult `json:"results"`
+}
+
+type ToolResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url,omitempty"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Erreur déclarée par l'upstream dans le stream ({"action":"error",...})
+type StreamError struct {
+	Type   string
+	Status int
+	Body   string
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("erreur upstream dans le stream: %s (%d)", e.Type, e.Status)
+}
+
+// Chunk brut du stream DuckDuckGo
+type upstreamChunk struct {
+	Role    string `json:"role"`
+	Message string `json:"message"`
+	Created int64  `json:"created"`
+	ID      string `json:"id"`
+	Action  string `json:"action"`
+	Model   string `json:"model"`
+	Type    string `json:"type"`
+	Status  int    `json:"status"`
+	Name    string `json:"name"`
+}
+
+// Décodeur des lignes "data:" du stream; retient l'identité déjà annoncée pour
+// ne publier un événement de métadonnées qu'à son premier passage ou à son changement
+type upstreamDecoder struct {
+	meta UpstreamMetadata
+}
+
+func (d *upstreamDecoder) decode(data string) ([]UpstreamEvent, error) {
+	var chunk upstreamChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil, err
+	}
+
+	if chunk.Action == "error" {
+		return []UpstreamEvent{{
+			Kind: EventError,
+			Err:  &StreamError{Type: chunk.Type, Status: chunk.Status, Body: data},
+		}}, nil
+	}
+
+	var events []UpstreamEvent
+	if (chunk.ID != "" && chunk.ID != d.meta.ID) || (chunk.Model != "" && chunk.Model != d.meta.Model) {
+		if chunk.ID != "" {
+			d.meta.ID = chunk.ID
+		}
+		if chunk.Model != "" {
+			d.meta.Model = chunk.Model
+		}
+		d.meta.Role = chunk.Role
+		d.meta.Created = chunk.Created
+		meta := d.meta
+		events = append(events, UpstreamEvent{Kind: EventMetadata, Metadata: &meta})
+	}
+
+	if chunk.Role == "tool" {
+		events = append(events, UpstreamEvent{
+			Kind: EventToolOutput,
+			Tool: normalizeToolOutput(chunk.Name, []byte(data)),
+		})
+		return events, nil
+	}
+
+	if chunk.Message != "" {
+		events = append(events, UpstreamEvent{Kind: EventTextDelta, Text: chunk.Message})
+	}
+	return events, nil
+}
+
+// Lecture d'un flux d'événements jusqu'à sa fin; renvoie l'erreur upstream ou de lecture
+func drainEvents(events chan UpstreamEvent, onEvent func(UpstreamEvent)) error {
+	for event := range events {
+		switch event.Kind {
+		case EventError:
+			return event.Err
+		case EventDone:
+			onEvent(event)
+			return nil
+		default:
+			onEvent(event)
+		}
+	}
+	return fmt.Errorf("stream interrompu")
+}
+
+// Lecture d'un flux d'événements en ne gardant que le texte
+func drainStream(events chan UpstreamEvent, onChunk func(string)) error {
+	return drainEvents(events, func(event UpstreamEvent) {
+		if event.Kind == EventTextDelta {
+			onChunk(event.Text)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Profil d'empreinte navigateur: headers d'identification envoyés à l'upstream
+type FingerprintProfile struct {
+	Name            string `json:"name"`
+	UserAgent       string `json:"user_agent"`
+	SecCHUA         string `json:"sec_ch_ua,omitempty"` // vide pour les navigateurs sans Client Hints
+	SecCHUAMobile   string `json:"sec_ch_ua_mobile,omitempty"`
+	SecCHUAPlatform string `json:"sec_ch_ua_platform,omitempty"`
+	AcceptLanguage  string `json:"accept_language"`
+	SecGPC          bool   `json:"sec_gpc,omitempty"`
+}
+
+// Profils intégrés, utilisés sans FINGERPRINTS_FILE
+var defaultFingerprints = []FingerprintProfile{
+	{
+		Name:            "brave-137-windows",
+		UserAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36",
+		SecCHUA:         `"Brave";v="137", "Chromium";v="137", "Not/A)Brand";v="24"`,
+		SecCHUAMobile:   "?0",
+		SecCHUAPlatform: `"Windows"`,
+		AcceptLanguage:  "fr-FR,fr;q=0.6",
+		SecGPC:          true,
+	},
+	{
+		Name:            "chrome-137-macos",
+		UserAgent:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36",
+		SecCHUA:         `"Google Chrome";v="137", "Chromium";v="137", "Not/A)Brand";v="24"`,
+		SecCHUAMobile:   "?0",
+		SecCHUAPlatform: `"macOS"`,
+		AcceptLanguage:  "en-US,en;q=0.9",
+	},
+	{
+		Name:           "firefox-139-linux",
+		UserAgent:      "Mozilla/5.0 (X11; Linux x86_64; rv:139.0) Gecko/20100101 Firefox/139.0",
+		AcceptLanguage: "en-US,en;q=0.5",
+	},
+}
+
+// Application des headers à une requête: headers communs fournis par l'appelant
+// puis headers d'identification du profil. net/http envoie les headers triés par
+// nom: l'ordre d'un navigateur ne peut pas être reproduit.
+func (f *FingerprintProfile) apply(req *http.Request, common [][2]string) {
+	headers := append([][2]string{}, common...)
+	headers = append(headers,
+		[2]string{"User-Agent", f.UserAgent},
+		[2]string{"Accept-Language", f.AcceptLanguage},
+	)
+	if f.SecCHUA != "" {
+		headers = append(headers,
+			[2]string{"Sec-CH-UA", f.SecCHUA},
+			[2]string{"Sec-CH-UA-Mobile", f.SecCHUAMobile},
+			[2]string{"Sec-CH-UA-Platform", f.SecCHUAPlatform},
+		)
+	}
+	if f.SecGPC {
+		headers = append(headers, [2]string{"Sec-GPC", "1"})
+	}
+
+	for _, header := range headers {
+		req.Header.Set(header[0], header[1])
+	}
+}
+
+// Ensemble des profils disponibles; un profil signalé par un 418 est écarté
+// pendant cooldown
+type FingerprintPool struct {
+	mu       sync.Mutex
+	profiles []*FingerprintProfile
+	flagged  map[*FingerprintProfile]time.Time
+	next     int
+	cooldown time.Duration
+}
+
+var fingerprints = NewFingerprintPool(defaultFingerprints, 10*time.Minute)
+
+func NewFingerprintPool(profiles []FingerprintProfile, cooldown time.Duration) *FingerprintPool {
+	p := &FingerprintPool{flagged: make(map[*FingerprintProfile]time.Time), cooldown: cooldown}
+	for i := range profiles {
+		profile := profiles[i]
+		p.profiles = append(p.profiles, &profile)
+	}
+	return p
+}
+
+// Chargement des profils depuis les variables d'environnement:
+// FINGERPRINTS_FILE (liste JSON de profils) et FINGERPRINT_COOLDOWN (défaut 10m)
+func configureFingerprints() {
+	cooldown := 10 * time.Minute
+	if value := os.Getenv("FINGERPRINT_COOLDOWN"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			log.Fatalf("❌ FINGERPRINT_COOLDOWN invalide: %s", value)
+		}
+		cooldown = d
+	}
+
+	profiles := defaultFingerprints
+	if path := os.Getenv("FINGERPRINTS_FILE"); path != "" {
+		loaded, err := loadFingerprints(path)
+		if err != nil {
+			log.Fatalf("❌ Profils d'empreinte invalides: %v", err)
+		}
+		profiles = loaded
+	}
+
+	fingerprints = NewFingerprintPool(profiles, cooldown)
+	log.Printf("🪪 %d profils d'empreinte navigateur", len(profiles))
+}
+
+func loadFingerprints(path string) ([]FingerprintProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []FingerprintProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("%s: aucun profil", path)
+	}
+	for i, profile := range profiles {
+		if profile.Name == "" || profile.UserAgent == "" {
+			return nil, fmt.Errorf("%s: profil %d sans name ou user_agent", path, i)
+		}
+	}
+	return profiles, nil
+}
+
+// Profil suivant (tourniquet), en évitant les profils signalés tant qu'il en reste d'autres
+func (p *FingerprintPool) Next() *FingerprintProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for range p.profiles {
+		profile := p.profiles[p.next%len(p.profiles)]
+		p.next++
+		if !p.isFlagged(profile) {
+			return profile
+		}
+	}
+	profile := p.profiles[p.next%len(p.profiles)]
+	p.next++
+	return profile
+}
+
+// Mise à l'écart d'un profil repéré par l'upstream
+func (p *FingerprintPool) Flag(profile *FingerprintProfile) {
+	if profile == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.flagged[profile] = time.Now()
+	log.Printf("🪪 Empreinte %s signalée, écartée pendant %s", profile.Name, p.cooldown)
+}
+
+func (p *FingerprintPool) IsFlagged(profile *FingerprintProfile) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.isFlagged(profile)
+}
+
+// À appeler avec le mutex verrouillé
+func (p *FingerprintPool) isFlagged(profile *FingerprintProfile) bool {
+	at, exists := p.flagged[profile]
+	if !exists {
+		return false
+	}
+	if time.Since(at) >= p.cooldown {
+		delete(p.flagged, profile)
+		return false
+	}
+	return true
+}
@@ -1,19 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Variables globales pour la gestion des sessions
 var (
-	chatSessions = make(map[string]*ChatSession)
-	sessionMutex sync.RWMutex
+	chatSessions   = make(map[string]Conversation)
+	sessionMutex   sync.RWMutex
+	sessionCounter int // dernier numéro attribué par generateSessionID
 )
 
 // Structures pour les requêtes/réponses API
@@ -21,28 +30,104 @@ type ChatRequest struct {
 	Messages  []Message `json:"messages" binding:"required"`
 	Model     string    `json:"model,omitempty"`
 	SessionID string    `json:"session_id,omitempty"`
+	Stream    bool      `json:"stream,omitempty"`
+	Legacy    bool      `json:"legacy,omitempty"`
+	N         int       `json:"n,omitempty"`
+
+	// Limites appliquées côté serveur (l'upstream n'en propose aucune)
+	Stop                StopSequences `json:"stop,omitempty"`
+	MaxTokens           int           `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int           `json:"max_completion_tokens,omitempty"`
+
+	// Réponse JSON (json_object ou json_schema), validée côté serveur
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+
+	// Outils de recherche DuckDuckGo (news, videos, local, weather): pour cette
+	// requête, ou enregistrés sur la session pour les requêtes suivantes
+	SearchTools        []string `json:"search_tools,omitempty"`
+	SessionSearchTools []string `json:"session_search_tools,omitempty"`
+}
+
+// Options de stream dérivées de stop et max_tokens / max_completion_tokens
+func (r *ChatRequest) streamOptions() StreamOptions {
+	maxTokens := r.MaxCompletionTokens
+	if maxTokens == 0 {
+		maxTokens = r.MaxTokens
+	}
+	return StreamOptions{Stop: r.Stop, MaxTokens: maxTokens}
+}
+
+// Outils de recherche demandés pour la requête et pour la session (nil si absents)
+func (r *ChatRequest) searchTools() (*ToolChoice, *ToolChoice, error) {
+	requestTools, err := parseSearchTools(r.SearchTools)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessionTools, err := parseSearchTools(r.SessionSearchTools)
+	if err != nil {
+		return nil, nil, err
+	}
+	return requestTools, sessionTools, nil
+}
+
+// Nombre maximal de réponses (n) par requête, chacune étant une conversation upstream
+const maxChoices = 8
+
+// Événement d'un échange parmi n: texte ou résultat d'outil reçu, ou fin (avec
+// raison ou erreur)
+type choiceEvent struct {
+	Index        int
+	Text         string
+	Tool         *ToolOutput
+	Done         bool
+	FinishReason string
+	Err          error
 }
 
 type ChatResponse struct {
-	Messages  string    `json:"messages"`
-	Model     string    `json:"model"`
-	SessionID string    `json:"session_id"`
-	Success   bool      `json:"success"`
-	Choices   []Choices `json:"choices"`
+	ID      string    `json:"id"`
+	Object  string    `json:"object"`
+	Created int64     `json:"created"`
+	Model   string    `json:"model"`
+	Choices []Choices `json:"choices"`
+	Usage   Usage     `json:"usage"`
+
+	// Champs historiques, uniquement renvoyés avec "legacy": true
+	Messages  string `json:"messages,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Success   bool   `json:"success,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type StreamResponse struct {
-	Chunk     string `json:"chunk,omitempty"`
-	Done      bool   `json:"done"`
-	SessionID string `json:"session_id"`
-	Error     string `json:"error,omitempty"`
+	Chunk      string      `json:"chunk,omitempty"`
+	ToolResult *ToolOutput `json:"tool_result,omitempty"`
+	Done       bool        `json:"done"`
+	SessionID  string      `json:"session_id"`
+	Error      string      `json:"error,omitempty"`
+
+	// Raison de fin ("stop" ou "length"), sur l'événement done
+	FinishReason string `json:"finish_reason,omitempty"`
 }
 
-type ModelInfo struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Alias       string `json:"alias"`
+// Objet modèle au format OpenAI (GET /v1/models)
+type ModelObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+	Root    string `json:"root,omitempty"`
+
+	// Métadonnées du registre
+	ContextLength int      `json:"context_length,omitempty"`
+	Capabilities  []string `json:"capabilities,omitempty"`
+	Deprecated    bool     `json:"deprecated,omitempty"`
+	Replacement   string   `json:"replacement,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -56,10 +141,29 @@ type Choices struct {
 	Message      Message     `json:"message"`
 	Index        int         `json:"index"`
 	FinishReason interface{} `json:"finish_reason"`
+
+	// Résultats des outils de recherche exécutés par l'upstream (hors schéma OpenAI)
+	ToolResults []ToolOutput `json:"tool_results,omitempty"`
+}
+
+// Structures du streaming au format OpenAI (chat.completion.chunk)
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+}
+
+type ChunkChoice struct {
+	Index        int          `json:"index"`
+	Delta        Message      `json:"delta"`
+	FinishReason interface{}  `json:"finish_reason"`
+	ToolResults  []ToolOutput `json:"tool_results,omitempty"`
 }
 
 // Fonction pour obtenir ou créer une session
-func getOrCreateSession(sessionID string, model Model) *ChatSession {
+func getOrCreateSession(sessionID string, model Model) Conversation {
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
 
@@ -68,99 +172,131 @@ func getOrCreateSession(sessionID string, model Model) *ChatSession {
 	}
 
 	if session, exists := chatSessions[sessionID]; exists {
-		if model != "" && session.Model != model {
-			session.Model = model
+		if model != "" && session.CurrentModel() != model {
+			session.SetModel(model)
 		}
 		return session
 	}
 
 	if model == "" {
-		model = GPT4Mini // Modèle par défaut
+		model = models.Default()
 	}
 
-	session := NewChatSession(model)
-	if session != nil {
-		chatSessions[sessionID] = session
+	session, err := upstream.NewConversation(model)
+	if err != nil {
+		log.Printf("⚠️ Impossible de créer la session: %v", err)
+		return nil
 	}
+	chatSessions[sessionID] = session
 	return session
 }
 
-// Génération d'un ID de session simple
+// Génération d'un ID de session simple, jamais réattribué même après removeSession
+// (à appeler avec sessionMutex verrouillé)
 func generateSessionID() string {
-	return fmt.Sprintf("session_%d", len(chatSessions)+1)
+	sessionCounter++
+	return fmt.Sprintf("session_%d", sessionCounter)
 }
 
-// Validation du modèle
+// Oubli d'une session que plus rien ne référence
+func removeSession(sessionID string) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	delete(chatSessions, sessionID)
+}
+
+// Validation du modèle (identifiant ou alias du registre, insensible à la casse);
+// un modèle retiré est redirigé vers son remplaçant
 func validateModel(modelStr string) (Model, error) {
-	switch strings.ToLower(modelStr) {
-	case "gpt-4o-mini", "gpt4mini", "":
-		return GPT4Mini, nil
-	case "claude-3-haiku-20240307", "claude", "claude3":
-		return Claude3, nil
-	case "meta-llama/llama-3.3-70b-instruct-turbo", "llama", "llama3":
-		return Llama, nil
-	case "mistralai/mistral-small-24b-instruct-2501", "mixtral", "mistral":
-		return Mixtral, nil
-	case "o4-mini", "o4mini":
-		return O4Mini, nil
-	default:
-		return "", fmt.Errorf("modèle non supporté: %s", modelStr)
+	model, redirected, err := models.Resolve(modelStr)
+	if redirected {
+		log.Printf("⚠️ Modèle %s retiré, remplacé par %s", modelStr, model)
 	}
+	return model, err
 }
 
 // Handler pour vérifier la santé de l'API
 func HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	health := gin.H{
 		"status":    "ok",
 		"service":   "DuckDuckGo Chat API",
 		"version":   "1.0.0",
 		"timestamp": fmt.Sprintf("%d", getCurrentTimestamp()),
-	})
+	}
+	if proxies != nil {
+		health["proxies"] = proxies.Status()
+	}
+	if breakers.threshold > 0 {
+		status := breakers.Status()
+		for _, breaker := range status {
+			if breaker.State != BreakerClosed {
+				health["status"] = "degraded"
+			}
+		}
+		health["breakers"] = status
+	}
+	c.JSON(http.StatusOK, health)
 }
 
 func getCurrentTimestamp() int64 {
 	return 1749828577156 // Timestamp fixe pour la cohérence
 }
 
+// Liste des objets modèles OpenAI: un par modèle du registre puis un par alias
+func modelObjects() []ModelObject {
+	created := getCurrentTimestamp() / 1000
+	list := models.List()
+
+	var objects []ModelObject
+	object := func(id string, info ModelInfo) ModelObject {
+		return ModelObject{
+			ID:            id,
+			Object:        "model",
+			Created:       created,
+			OwnedBy:       info.OwnedBy,
+			ContextLength: info.ContextLength,
+			Capabilities:  info.Capabilities,
+			Deprecated:    info.Deprecated,
+			Replacement:   string(info.Replacement),
+		}
+	}
+	for _, info := range list {
+		objects = append(objects, object(string(info.ID), info))
+	}
+	for _, info := range list {
+		for _, alias := range info.Aliases {
+			aliasObject := object(alias, info)
+			aliasObject.Root = string(info.ID)
+			objects = append(objects, aliasObject)
+		}
+	}
+	return objects
+}
+
 // Handler pour obtenir la liste des modèles disponibles
 func GetModels(c *gin.Context) {
-	models := []ModelInfo{
-		{
-			ID:          string(GPT4Mini),
-			Name:        "GPT-4o Mini",
-			Description: "Modèle général rapide et équilibré",
-			Alias:       "gpt-4o-mini",
-		},
-		{
-			ID:          string(Claude3),
-			Name:        "Claude 3 Haiku",
-			Description: "Excellente pour l'écriture créative et les explications",
-			Alias:       "claude-3-haiku",
-		},
-		{
-			ID:          string(Llama),
-			Name:        "Llama 3.3 70B",
-			Description: "Spécialisé en programmation et tâches techniques",
-			Alias:       "llama",
-		},
-		{
-			ID:          string(Mixtral),
-			Name:        "Mistral Small",
-			Description: "Excellent pour l'analyse et le raisonnement",
-			Alias:       "mixtral",
-		},
-		{
-			ID:          string(O4Mini),
-			Name:        "o4-mini",
-			Description: "Très rapide pour les réponses courtes",
-			Alias:       "o4mini",
-		},
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   modelObjects(),
+	})
+}
+
+// Handler pour obtenir un modèle par identifiant ou alias
+func GetModel(c *gin.Context) {
+	// L'identifiant peut contenir des "/" (ex: meta-llama/Llama-3.3-70B-Instruct-Turbo)
+	id := strings.TrimPrefix(c.Param("id"), "/")
+
+	for _, object := range modelObjects() {
+		if strings.EqualFold(object.ID, id) {
+			c.JSON(http.StatusOK, object)
+			return
+		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"models":  models,
-		"success": true,
-		"count":   len(models),
+	c.JSON(http.StatusNotFound, ErrorResponse{
+		Error:   fmt.Sprintf("modèle non trouvé: %s", id),
+		Code:    404,
+		Success: false,
 	})
 }
 
@@ -205,6 +341,38 @@ func ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if err := req.ResponseFormat.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   err.Error(),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+
+	requestTools, sessionTools, err := req.searchTools()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   err.Error(),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+
+	n := req.N
+	if n < 1 {
+		n = 1
+	}
+	if n > maxChoices {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   fmt.Sprintf("n ne peut pas dépasser %d", maxChoices),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+
 	// Obtenir ou créer la session
 	session := getOrCreateSession(req.SessionID, model)
 	if session == nil {
@@ -216,73 +384,370 @@ func ChatHandler(c *gin.Context) {
 		return
 	}
 
+	// Les choices supplémentaires utilisent chacune leur propre session (et VQD)
+	extras := newConversations(model, n-1)
+	if extras == nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Impossible de créer la session de chat",
+			Code:    500,
+			Success: false,
+		})
+		return
+	}
+	sessions := append([]Conversation{session}, extras...)
+	if sessionTools != nil {
+		session.SetTools(*sessionTools)
+	}
+	opts := req.streamOptions()
+	opts.Tools = requestTools
+	if opts.Tools == nil {
+		// Les choices supplémentaires suivent les outils de la session, y compris
+		// ceux enregistrés par une requête précédente
+		tools := session.CurrentTools()
+		opts.Tools = &tools
+	}
+
 	// Envoyer le message
+	prompt := buildContent(req.Messages)
+	var events chan choiceEvent
+	if req.ResponseFormat.wantsJSON() {
+		prompt = buildContent(append(req.Messages, Message{Role: "system", Content: req.ResponseFormat.instruction()}))
+		events, err = fanOutStructured(c.Request.Context(), sessions, prompt, req.ResponseFormat, opts)
+	} else {
+		events, err = fanOutChat(c.Request.Context(), sessions, prompt, opts)
+	}
+	setAttemptsHeader(c, sessions...)
 
-	resp, err := session.SendMessage(buildContent(req.Messages))
+	var structuredErr *StructuredOutputError
+	if errors.As(err, &structuredErr) {
+		c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+			Error:   structuredErr.Error(),
+			Code:    422,
+			Success: false,
+		})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
+		setRetryAfter(c, err)
+		status, _ := errorStatus(err)
+		c.JSON(status, ErrorResponse{
 			Error:   fmt.Sprintf("Erreur de chat: %v", err),
-			Code:    500,
+			Code:    status,
 			Success: false,
 		})
 		return
 	}
-	defer resp.Body.Close()
 
-	// Lire la réponse complète
-	var completeResponse strings.Builder
-	stream, errChan := session.ProcessStreamResponse(resp)
+	// L'ID de session est toujours exposé en header, hors du schéma OpenAI
+	sessionID := lookupSessionID(req.SessionID, session)
+	c.Header("X-Session-ID", sessionID)
 
-	for {
-		select {
-		case chunk, ok := <-stream:
-			if !ok {
-				goto done
-			}
-			completeResponse.WriteString(chunk)
-			//log.Printf("completeResponse: %#v", completeResponse)
-		case err := <-errChan:
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, ErrorResponse{
-					Error:   fmt.Sprintf("Erreur de stream: %v \n %#v", err, completeResponse),
-					Code:    500,
-					Success: false,
-				})
-				return
-			}
+	// Streaming au format OpenAI si demandé par le client
+	if req.Stream {
+		streamChatCompletion(c, string(session.CurrentModel()), events, n)
+		return
+	}
+
+	// Lire les réponses complètes
+	answers := make([]string, n)
+	finishReasons := make([]string, n)
+	toolResults := make([][]ToolOutput, n)
+	for event := range events {
+		if event.Err != nil {
+			discardChoiceEvents(events)
+			status, _ := errorStatus(event.Err)
+			c.JSON(status, ErrorResponse{
+				Error:   fmt.Sprintf("Erreur de stream: %v \n %#v", event.Err, answers[event.Index]),
+				Code:    status,
+				Success: false,
+			})
+			return
+		}
+		answers[event.Index] += event.Text
+		if event.Tool != nil {
+			toolResults[event.Index] = append(toolResults[event.Index], *event.Tool)
+		}
+		if event.Done {
+			finishReasons[event.Index] = event.FinishReason
 		}
 	}
 
-done:
-	// Obtenir l'ID de session
-	sessionID := req.SessionID
-	if sessionID == "" {
-		// Trouver l'ID de session de cette instance
-		sessionMutex.RLock()
-		for id, sess := range chatSessions {
-			if sess == session {
-				sessionID = id
-				break
+	setUpstreamMessageHeader(c, session)
+	response := ChatResponse{
+		ID:      generateID("chatcmpl-"),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   respondingModel(session),
+		Usage:   newUsage(prompt, strings.Join(answers, "")),
+	}
+	for index, answer := range answers {
+		response.Choices = append(response.Choices, Choices{
+			Index: index,
+			Message: Message{
+				Role:    "assistant",
+				Content: answer,
+			},
+			FinishReason: finishReasons[index],
+			ToolResults:  toolResults[index],
+		})
+	}
+
+	if req.Legacy {
+		response.Messages = answers[0]
+		response.SessionID = sessionID
+		response.Success = true
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Envoi sur une conversation éphémère, non enregistrée parmi les sessions, pour les
+// API dont chaque requête porte tout l'historique (Anthropic, Ollama). L'erreur
+// renvoyée est déjà formulée pour le client (création ou envoi) et reste classable
+// par errorStatus; Retry-After et le nombre de tentatives sont posés en headers.
+func sendEphemeral(c *gin.Context, model Model, prompt string, opts StreamOptions) (Conversation, chan UpstreamEvent, error) {
+	session, err := upstream.NewConversation(model)
+	if err != nil {
+		setRetryAfter(c, err)
+		return nil, nil, fmt.Errorf("Impossible de créer la session de chat: %w", err)
+	}
+
+	events, err := session.Send(c.Request.Context(), prompt, opts)
+	setAttemptsHeader(c, session)
+	if err != nil {
+		setRetryAfter(c, err)
+		return nil, nil, fmt.Errorf("Erreur de chat: %w", err)
+	}
+	return session, events, nil
+}
+
+// Création concurrente de sessions éphémères (non enregistrées); nil si l'une échoue
+func newConversations(model Model, count int) []Conversation {
+	sessions := make([]Conversation, count)
+	errs := make([]error, count)
+
+	var wg sync.WaitGroup
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sessions[i], errs[i] = upstream.NewConversation(model)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			log.Printf("⚠️ Impossible de créer la session: %v", err)
+			return nil
+		}
+	}
+	return sessions
+}
+
+// Lancement concurrent d'un échange par session. Les envois sont attendus avant de
+// renvoyer le flux fusionné, pour qu'une erreur upstream reste une réponse JSON.
+func fanOutChat(ctx context.Context, sessions []Conversation, prompt string, opts StreamOptions) (chan choiceEvent, error) {
+	streams := make([]chan UpstreamEvent, len(sessions))
+	errs := make([]error, len(sessions))
+
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session Conversation) {
+			defer wg.Done()
+			streams[i], errs[i] = session.Send(ctx, prompt, opts)
+		}(i, session)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			// Les réponses déjà obtenues sont lues jusqu'au bout puis abandonnées
+			for i := range streams {
+				if streams[i] != nil {
+					go drainStream(streams[i], func(string) {})
+				}
 			}
+			return nil, err
 		}
-		sessionMutex.RUnlock()
-	}
-
-	c.JSON(http.StatusOK, ChatResponse{
-		Messages:  completeResponse.String(),
-		Model:     string(session.Model),
-		SessionID: sessionID,
-		Choices: []Choices{
-			{
-				Index: 0,
-				Message: Message{
-					Content: completeResponse.String(),
+	}
+
+	events := make(chan choiceEvent)
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session Conversation) {
+			defer wg.Done()
+			err := drainEvents(streams[i], func(event UpstreamEvent) {
+				switch event.Kind {
+				case EventTextDelta:
+					events <- choiceEvent{Index: i, Text: event.Text}
+				case EventToolOutput:
+					events <- choiceEvent{Index: i, Tool: event.Tool}
+				}
+			})
+			events <- choiceEvent{Index: i, Done: true, FinishReason: session.LastResult().FinishReason, Err: err}
+		}(i, session)
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	return events, nil
+}
+
+// Vidage des événements restants pour libérer les goroutines d'un fan-out abandonné
+func discardChoiceEvents(events chan choiceEvent) {
+	go func() {
+		for range events {
+		}
+	}()
+}
+
+// Calcul du bloc usage à partir du texte envoyé et reçu
+func newUsage(prompt, completion string) Usage {
+	promptTokens := estimateTokens(prompt)
+	completionTokens := estimateTokens(completion)
+	return Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
+
+// Estimation du nombre de tokens (l'upstream ne fournit aucun décompte):
+// environ 4 caractères par token
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// Envoi des réponses sous forme de chunks chat.completion.chunk (protocole OpenAI).
+// Avec n > 1, les chunks des différentes choices sont entrelacés au fil de l'eau.
+func streamChatCompletion(c *gin.Context, model string, events chan choiceEvent, n int) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	chunk := ChatCompletionChunk{
+		ID:      generateID("chatcmpl-"),
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   model,
+	}
+
+	// Premiers chunks: annonce du rôle pour chaque choice
+	for index := 0; index < n; index++ {
+		chunk.Choices = []ChunkChoice{{Index: index, Delta: Message{Role: "assistant"}}}
+		writeSSEData(c, chunk)
+	}
+
+	for event := range events {
+		if event.Err != nil {
+			discardChoiceEvents(events)
+			_, code := errorStatus(event.Err)
+			writeSSEData(c, gin.H{
+				"error": gin.H{
+					"message": fmt.Sprintf("Erreur de stream: %v", event.Err),
+					"type":    code,
 				},
-				FinishReason: nil,
-			},
-		},
-		Success: true,
+			})
+			return
+		}
+
+		// Dernier chunk d'une choice: raison de fin
+		if event.Done {
+			chunk.Choices = []ChunkChoice{{Index: event.Index, Delta: Message{}, FinishReason: event.FinishReason}}
+		} else if event.Tool != nil {
+			chunk.Choices = []ChunkChoice{{Index: event.Index, Delta: Message{}, ToolResults: []ToolOutput{*event.Tool}}}
+		} else {
+			chunk.Choices = []ChunkChoice{{Index: event.Index, Delta: Message{Content: event.Text}}}
+		}
+		writeSSEData(c, chunk)
+	}
+
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
+
+// Écriture d'un événement SSE "data: <json>" sans nom d'événement
+func writeSSEData(c *gin.Context, v interface{}) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+	c.Writer.Flush()
+}
+
+// Écriture d'un événement SSE nommé "event: <nom>" suivi de "data: <json>"
+func writeSSEEvent(c *gin.Context, event string, v interface{}) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, data)
+	c.Writer.Flush()
+}
+
+// Échange complet sur une session: envoi du contenu puis lecture de toute la réponse
+func exchange(ctx context.Context, session Conversation, content string, opts StreamOptions, onChunk func(string)) (string, error) {
+	events, err := session.Send(ctx, content, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var answer strings.Builder
+	err = drainStream(events, func(chunk string) {
+		answer.WriteString(chunk)
+		onChunk(chunk)
 	})
+	return answer.String(), err
+}
+
+// Recherche de l'ID d'une session lorsque le client n'en a pas fourni
+func lookupSessionID(sessionID string, session Conversation) string {
+	if sessionID != "" {
+		return sessionID
+	}
+
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	for id, sess := range chatSessions {
+		if sess == session {
+			return id
+		}
+	}
+	return ""
+}
+
+// Nombre de requêtes upstream des derniers envois, exposé en header
+func setAttemptsHeader(c *gin.Context, sessions ...Conversation) {
+	attempts := 0
+	for _, session := range sessions {
+		attempts += session.LastResult().Attempts
+	}
+	if attempts > 0 {
+		c.Header("X-Upstream-Attempts", strconv.Itoa(attempts))
+	}
+}
+
+// Modèle annoncé par l'upstream pour la dernière réponse, à défaut celui de la session
+// (l'identifiant upstream du modèle de la session est rendu sous son identifiant d'API)
+func respondingModel(session Conversation) string {
+	current := session.CurrentModel()
+	if model := session.LastResult().Model; model != "" && Model(model) != models.UpstreamID(current) {
+		return model
+	}
+	return string(current)
+}
+
+// Identifiant upstream de la dernière réponse, exposé en header (réponses non streamées)
+func setUpstreamMessageHeader(c *gin.Context, session Conversation) {
+	if id := session.LastResult().MessageID; id != "" {
+		c.Header("X-Upstream-Message-ID", id)
+	}
+}
+
+// Génération d'un identifiant aléatoire préfixé (chatcmpl-, msg_, ...)
+func generateID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
 }
 
 // Handler pour le chat en streaming
@@ -309,6 +774,16 @@ func StreamChatHandler(c *gin.Context) {
 		return
 	}
 
+	requestTools, sessionTools, err := req.searchTools()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   err.Error(),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+
 	// Obtenir ou créer la session
 	session := getOrCreateSession(req.SessionID, model)
 	if session == nil {
@@ -319,6 +794,9 @@ func StreamChatHandler(c *gin.Context) {
 		})
 		return
 	}
+	if sessionTools != nil {
+		session.SetTools(*sessionTools)
+	}
 
 	// Configuration pour le streaming
 	c.Header("Content-Type", "text/event-stream")
@@ -327,7 +805,10 @@ func StreamChatHandler(c *gin.Context) {
 	c.Header("Access-Control-Allow-Origin", "*")
 
 	// Envoyer le message
-	resp, err := session.SendMessage(buildContent(req.Messages))
+	opts := req.streamOptions()
+	opts.Tools = requestTools
+	events, err := session.Send(c.Request.Context(), buildContent(req.Messages), opts)
+	setAttemptsHeader(c, session)
 	if err != nil {
 		streamResp := StreamResponse{
 			Done:      true,
@@ -338,61 +819,46 @@ func StreamChatHandler(c *gin.Context) {
 		c.SSEvent("error", string(data))
 		return
 	}
-	defer resp.Body.Close()
 
 	// Obtenir l'ID de session pour la réponse
-	sessionID := req.SessionID
-	if sessionID == "" {
-		sessionMutex.RLock()
-		for id, sess := range chatSessions {
-			if sess == session {
-				sessionID = id
-				break
-			}
-		}
-		sessionMutex.RUnlock()
-	}
+	sessionID := lookupSessionID(req.SessionID, session)
 
 	// Traiter le stream
-	stream, errChan := session.ProcessStreamResponse(resp)
-
-	for {
-		select {
-		case chunk, ok := <-stream:
-			if !ok {
-				// Stream terminé
-				streamResp := StreamResponse{
-					Done:      true,
-					SessionID: sessionID,
-				}
-				data, _ := json.Marshal(streamResp)
-				c.SSEvent("done", string(data))
-				return
-			}
-
-			// Envoyer le chunk
-			streamResp := StreamResponse{
-				Chunk:     chunk,
-				Done:      false,
-				SessionID: sessionID,
-			}
-			data, _ := json.Marshal(streamResp)
-			c.SSEvent("chunk", string(data))
-			c.Writer.Flush()
-
-		case err := <-errChan:
-			if err != nil {
-				streamResp := StreamResponse{
-					Done:      true,
-					SessionID: sessionID,
-					Error:     fmt.Sprintf("Erreur de stream: %v", err),
-				}
-				data, _ := json.Marshal(streamResp)
-				c.SSEvent("error", string(data))
-				return
-			}
+	err = drainEvents(events, func(event UpstreamEvent) {
+		// Envoyer le chunk ou le résultat d'outil
+		var name string
+		streamResp := StreamResponse{SessionID: sessionID}
+		switch event.Kind {
+		case EventTextDelta:
+			name, streamResp.Chunk = "chunk", event.Text
+		case EventToolOutput:
+			name, streamResp.ToolResult = "tool", event.Tool
+		default:
+			return
+		}
+		data, _ := json.Marshal(streamResp)
+		c.SSEvent(name, string(data))
+		c.Writer.Flush()
+	})
+	if err != nil {
+		streamResp := StreamResponse{
+			Done:      true,
+			SessionID: sessionID,
+			Error:     fmt.Sprintf("Erreur de stream: %v", err),
 		}
+		data, _ := json.Marshal(streamResp)
+		c.SSEvent("error", string(data))
+		return
+	}
+
+	// Stream terminé
+	streamResp := StreamResponse{
+		Done:         true,
+		SessionID:    sessionID,
+		FinishReason: session.LastResult().FinishReason,
 	}
+	data, _ := json.Marshal(streamResp)
+	c.SSEvent("done", string(data))
 }
 
 // Handler pour nettoyer une session de chat
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Router de test adossé à un fournisseur scripté (restauré en fin de test)
+func newScriptedRouter(t *testing.T, replies ...ScriptedReply) (*gin.Engine, *ScriptedProvider) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	provider := NewScriptedProvider(replies...)
+	previous := upstream
+	upstream = provider
+	t.Cleanup(func() { upstream = previous })
+	return newRouter(), provider
+}
+
+func postJSON(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// Réponse 418 de l'upstream, telle que la renvoie ChatSession.post
+var challengeError = &UpstreamError{StatusCode: http.StatusTeapot, Status: "418 I'm a teapot", Body: `{"type":"ERR_CHALLENGE"}`}
+
+const chatBody = `{"messages": [{"role": "user", "content": "Bonjour"}]}`
+
+func streamBody(body string) string {
+	return strings.Replace(body, "{", `{"stream": true, `, 1)
+}
+
+func TestChatCompletionsReply(t *testing.T) {
+	router, provider := newScriptedRouter(t, ScriptedReply{Chunks: []string{"Bon", "jour !"}})
+
+	recorder := postJSON(router, "/v1/chat/completions", chatBody)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("statut %d: %s", recorder.Code, recorder.Body)
+	}
+	var response ChatResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Choices) != 1 || response.Choices[0].Message.Content != "Bonjour !" {
+		t.Fatalf("réponse inattendue: %s", recorder.Body)
+	}
+	if recorder.Header().Get("X-Session-ID") == "" {
+		t.Error("X-Session-ID absent")
+	}
+	if len(provider.Sent) != 1 || !strings.Contains(provider.Sent[0], "Bonjour") {
+		t.Errorf("contenus envoyés: %q", provider.Sent)
+	}
+}
+
+func TestChatCompletionsStreamReply(t *testing.T) {
+	router, _ := newScriptedRouter(t, ScriptedReply{Chunks: []string{"Bon", "jour !"}})
+
+	recorder := postJSON(router, "/v1/chat/completions", streamBody(chatBody))
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("statut %d, type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{`"content":"Bon"`, `"content":"jour !"`, `"finish_reason":"stop"`, "data: [DONE]"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%s absent du stream:\n%s", want, body)
+		}
+	}
+}
+
+func TestChallengeMapsToServiceUnavailable(t *testing.T) {
+	cases := []struct {
+		path, body string
+		status     int
+		want       string
+	}{
+		{"/v1/chat/completions", chatBody, http.StatusServiceUnavailable, "Erreur de chat"},
+		{"/v1/chat/completions", streamBody(chatBody), http.StatusServiceUnavailable, "Erreur de chat"},
+		{"/v1/messages", `{"model": "claude-3-haiku", "max_tokens": 50, "messages": [{"role": "user", "content": "Bonjour"}]}`, http.StatusServiceUnavailable, "overloaded_error"},
+		{"/v1/messages", `{"model": "claude-3-haiku", "stream": true, "max_tokens": 50, "messages": [{"role": "user", "content": "Bonjour"}]}`, http.StatusServiceUnavailable, "overloaded_error"},
+		{"/api/chat", `{"model": "llama", "stream": false, "messages": [{"role": "user", "content": "Bonjour"}]}`, http.StatusServiceUnavailable, "Erreur de chat"},
+		{"/api/chat", `{"model": "llama", "messages": [{"role": "user", "content": "Bonjour"}]}`, http.StatusServiceUnavailable, "Erreur de chat"},
+	}
+	for _, tc := range cases {
+		router, _ := newScriptedRouter(t, ScriptedReply{SendErr: challengeError})
+		recorder := postJSON(router, tc.path, tc.body)
+		if recorder.Code != tc.status || !strings.Contains(recorder.Body.String(), tc.want) {
+			t.Errorf("%s %s: statut %d, corps %s", tc.path, tc.body, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestMidStreamError(t *testing.T) {
+	reply := ScriptedReply{Chunks: []string{"Début"}, StreamErr: errors.New("connexion coupée")}
+
+	// Sans stream: la réponse partielle est abandonnée au profit d'une erreur
+	router, _ := newScriptedRouter(t, reply)
+	recorder := postJSON(router, "/v1/chat/completions", chatBody)
+	if recorder.Code != http.StatusInternalServerError || !strings.Contains(recorder.Body.String(), "connexion coupée") {
+		t.Errorf("statut %d, corps %s", recorder.Code, recorder.Body)
+	}
+
+	// En stream: le texte déjà reçu est transmis, suivi d'un événement d'erreur
+	streams := map[string]string{
+		"/v1/chat/completions": streamBody(chatBody),
+		"/v1/messages":         `{"model": "claude-3-haiku", "stream": true, "max_tokens": 50, "messages": [{"role": "user", "content": "Bonjour"}]}`,
+		"/v1/chat/stream":      chatBody,
+		"/api/chat":            `{"model": "llama", "messages": [{"role": "user", "content": "Bonjour"}]}`,
+	}
+	for path, body := range streams {
+		router, _ := newScriptedRouter(t, reply)
+		recorder := postJSON(router, path, body)
+		text := recorder.Body.String()
+		if recorder.Code != http.StatusOK || !strings.Contains(text, "Début") || !strings.Contains(text, "connexion coupée") {
+			t.Errorf("%s: statut %d, stream:\n%s", path, recorder.Code, text)
+		}
+		if strings.Contains(text, "[DONE]") || strings.Contains(text, "message_stop") {
+			t.Errorf("%s: stream terminé normalement malgré l'erreur:\n%s", path, text)
+		}
+	}
+}
+
+func TestMessagesReply(t *testing.T) {
+	router, _ := newScriptedRouter(t, ScriptedReply{Chunks: []string{"Salut"}})
+
+	recorder := postJSON(router, "/v1/messages", `{"model": "claude-3-haiku", "max_tokens": 50, "messages": [{"role": "user", "content": "Bonjour"}]}`)
+	var response AnthropicResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if recorder.Code != http.StatusOK || len(response.Content) != 1 || response.Content[0].Text != "Salut" || response.StopReason != "end_turn" {
+		t.Fatalf("statut %d, corps %s", recorder.Code, recorder.Body)
+	}
+	if recorder.Header().Get("X-Session-ID") != "" {
+		t.Error("X-Session-ID exposé pour une requête sans session")
+	}
+}
+
+// Les outils enregistrés sur la session par une requête précédente valent pour
+// toutes les choices, pas seulement la première
+func TestChatCompletionsChoicesShareSessionTools(t *testing.T) {
+	router, provider := newScriptedRouter(t)
+
+	postJSON(router, "/v1/chat/completions", `{"session_id": "outils", "session_search_tools": ["news"], "messages": [{"role": "user", "content": "Bonjour"}]}`)
+	recorder := postJSON(router, "/v1/chat/completions", `{"session_id": "outils", "n": 3, "messages": [{"role": "user", "content": "Actualités"}]}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("statut %d: %s", recorder.Code, recorder.Body)
+	}
+	if len(provider.SentTools) != 4 {
+		t.Fatalf("%d envois", len(provider.SentTools))
+	}
+	for i, tools := range provider.SentTools {
+		if tools != (ToolChoice{NewsSearch: true}) {
+			t.Errorf("envoi %d: outils %+v", i, tools)
+		}
+	}
+}
+
+// Échec de création de la conversation éphémère, classé comme une erreur d'envoi
+func TestEphemeralConversationCreationError(t *testing.T) {
+	router, provider := newScriptedRouter(t)
+	provider.NewErr = challengeError
+
+	for path, body := range map[string]string{
+		"/v1/messages": `{"model": "claude-3-haiku", "max_tokens": 50, "messages": [{"role": "user", "content": "Bonjour"}]}`,
+		"/api/chat":    `{"model": "llama", "stream": false, "messages": [{"role": "user", "content": "Bonjour"}]}`,
+	} {
+		recorder := postJSON(router, path, body)
+		if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "Impossible de créer la session de chat") {
+			t.Errorf("%s: statut %d, corps %s", path, recorder.Code, recorder.Body)
+		}
+	}
+}
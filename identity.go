@@ -0,0 +1,251 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Identité upstream: ce qui fait passer les requêtes pour un même navigateur
+// (jar de cookies, empreinte, proxy sortant, version du front). Le token VQD et
+// le hash x-vqd-hash-1 restent propres à chaque conversation.
+type Identity struct {
+	ID          string
+	Jar         *cookiejar.Jar
+	Fingerprint *FingerprintProfile
+	Proxy       *UpstreamProxy
+	FeVersion   string
+	CreatedAt   time.Time
+
+	// Compteurs depuis la création ou la dernière sortie de quarantaine, protégés
+	// par le mutex du gestionnaire
+	successes   int
+	challenges  int // 418
+	rateLimits  int // 429
+	failures    int // erreurs réseau
+	quarantines int
+	assignments int
+	quarantined time.Time // en quarantaine jusqu'à cette date
+}
+
+// Résultat d'une requête upstream faite avec une identité
+type identityOutcome int
+
+const (
+	outcomeSuccess identityOutcome = iota
+	outcomeChallenge
+	outcomeRateLimit
+	outcomeFailure
+)
+
+// Client HTTP passant par le jar et le proxy de l'identité
+func (i *Identity) client(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Jar: i.Jar, Transport: i.Proxy.Transport()}
+}
+
+// Score de santé entre 0 et 1: part des succès, un 418 pesant 3 échecs et un 429 2
+// (à appeler avec le mutex du gestionnaire verrouillé)
+func (i *Identity) score() float64 {
+	good := float64(i.successes + 1)
+	return good / (good + float64(3*i.challenges+2*i.rateLimits+i.failures))
+}
+
+// Identité utilisable: hors quarantaine, avec une empreinte et un proxy non écartés
+// (à appeler avec le mutex du gestionnaire verrouillé)
+func (i *Identity) healthy(now time.Time) bool {
+	return !now.Before(i.quarantined) && !fingerprints.IsFlagged(i.Fingerprint) && !proxies.IsBanned(i.Proxy)
+}
+
+// Gestionnaire des identités: jusqu'à size identités créées à la demande, attribuées
+// aux nouvelles sessions par score, et mises en quarantaine pendant cooldown lorsque
+// leur score passe sous minScore
+type IdentityManager struct {
+	mu         sync.Mutex
+	identities []*Identity
+	size       int
+	cooldown   time.Duration
+	minScore   float64
+	counter    int
+}
+
+var identities = NewIdentityManager(8, 10*time.Minute, 0.5)
+
+func NewIdentityManager(size int, cooldown time.Duration, minScore float64) *IdentityManager {
+	return &IdentityManager{size: size, cooldown: cooldown, minScore: minScore}
+}
+
+// Configuration depuis les variables d'environnement: IDENTITY_POOL_SIZE (défaut 8),
+// IDENTITY_COOLDOWN (défaut 10m) et IDENTITY_MIN_SCORE (défaut 0.5)
+func configureIdentities() {
+	size := 8
+	if value := os.Getenv("IDENTITY_POOL_SIZE"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			log.Fatalf("❌ IDENTITY_POOL_SIZE invalide: %s", value)
+		}
+		size = n
+	}
+	cooldown := 10 * time.Minute
+	if value := os.Getenv("IDENTITY_COOLDOWN"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			log.Fatalf("❌ IDENTITY_COOLDOWN invalide: %s", value)
+		}
+		cooldown = d
+	}
+	minScore := 0.5
+	if value := os.Getenv("IDENTITY_MIN_SCORE"); value != "" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 1 {
+			log.Fatalf("❌ IDENTITY_MIN_SCORE invalide: %s", value)
+		}
+		minScore = f
+	}
+
+	identities = NewIdentityManager(size, cooldown, minScore)
+	log.Printf("🧑 Identités upstream: %d au plus, quarantaine de %s sous un score de %.2f", size, cooldown, minScore)
+}
+
+// Nouvelle identité avec un jar neuf, l'empreinte et le proxy suivants
+// (à appeler avec le mutex verrouillé)
+func (m *IdentityManager) create() *Identity {
+	m.counter++
+	identity := &Identity{
+		ID:          "id-" + strconv.Itoa(m.counter),
+		Jar:         newDuckDuckGoJar(),
+		Fingerprint: fingerprints.Next(),
+		Proxy:       proxies.Next(),
+		FeVersion:   GetDynamicHeaders().FeVersion,
+		CreatedAt:   time.Now(),
+	}
+	m.identities = append(m.identities, identity)
+	log.Printf("🧑 Identité %s créée (%s via %s)", identity.ID, identity.Fingerprint.Name, identity.Proxy)
+	return identity
+}
+
+// Identité pour une nouvelle session ou un nouveau token: une nouvelle tant que la
+// limite n'est pas atteinte, sinon la plus saine (à score égal, la moins attribuée).
+// Si aucune n'est saine, la plus ancienne est remplacée.
+func (m *IdentityManager) Assign() *Identity {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var best *Identity
+	for _, identity := range m.identities {
+		// Nouveau départ à la sortie de quarantaine
+		if !identity.quarantined.IsZero() && !now.Before(identity.quarantined) {
+			identity.quarantined = time.Time{}
+			identity.successes, identity.challenges, identity.rateLimits, identity.failures = 0, 0, 0, 0
+		}
+		if !identity.healthy(now) {
+			continue
+		}
+		if best == nil || identity.score() > best.score() ||
+			(identity.score() == best.score() && identity.assignments < best.assignments) {
+			best = identity
+		}
+	}
+
+	if len(m.identities) < m.size && (best == nil || best.assignments > 0) {
+		best = m.create()
+	}
+	if best == nil {
+		m.identities = m.identities[1:]
+		best = m.create()
+	}
+	best.assignments++
+	return best
+}
+
+// Prise en compte du résultat d'une requête; une identité dont le score passe
+// sous le minimum est mise en quarantaine
+func (m *IdentityManager) Report(identity *Identity, outcome identityOutcome) {
+	if identity == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch outcome {
+	case outcomeSuccess:
+		identity.successes++
+		return
+	case outcomeChallenge:
+		identity.challenges++
+	case outcomeRateLimit:
+		identity.rateLimits++
+	case outcomeFailure:
+		identity.failures++
+	}
+
+	if score := identity.score(); score < m.minScore && !time.Now().Before(identity.quarantined) {
+		identity.quarantined = time.Now().Add(m.cooldown)
+		identity.quarantines++
+		log.Printf("🧑 Identité %s en quarantaine pendant %s (score %.2f)", identity.ID, m.cooldown, score)
+	}
+}
+
+// Identité encore utilisable (tokens du pool VQD)
+func (m *IdentityManager) IsHealthy(identity *Identity) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return identity.healthy(time.Now())
+}
+
+// État d'une identité, pour l'endpoint d'administration
+type IdentityStatus struct {
+	ID               string  `json:"id"`
+	State            string  `json:"state"`
+	Score            float64 `json:"score"`
+	Fingerprint      string  `json:"fingerprint"`
+	Proxy            string  `json:"proxy"`
+	Successes        int     `json:"successes"`
+	Challenges       int     `json:"challenges"`
+	RateLimits       int     `json:"rate_limits"`
+	Failures         int     `json:"failures"`
+	Quarantines      int     `json:"quarantines"`
+	Assignments      int     `json:"assignments"`
+	CreatedAt        string  `json:"created_at"`
+	QuarantinedUntil string  `json:"quarantined_until,omitempty"`
+}
+
+func (m *IdentityManager) Status() []IdentityStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	status := []IdentityStatus{}
+	for _, identity := range m.identities {
+		s := IdentityStatus{
+			ID:          identity.ID,
+			State:       "healthy",
+			Score:       identity.score(),
+			Fingerprint: identity.Fingerprint.Name,
+			Proxy:       identity.Proxy.String(),
+			Successes:   identity.successes,
+			Challenges:  identity.challenges,
+			RateLimits:  identity.rateLimits,
+			Failures:    identity.failures,
+			Quarantines: identity.quarantines,
+			Assignments: identity.assignments,
+			CreatedAt:   identity.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		switch {
+		case now.Before(identity.quarantined):
+			s.State = "quarantined"
+			s.QuarantinedUntil = identity.quarantined.UTC().Format(time.RFC3339)
+		case !identity.healthy(now):
+			// Empreinte signalée ou proxy banni
+			s.State = "unavailable"
+		}
+		status = append(status, s)
+	}
+	sort.Slice(status, func(a, b int) bool { return status[a].Score > status[b].Score })
+	return status
+}
@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// Sous-commande: émulateur de l'upstream DuckDuckGo
+	if len(os.Args) > 1 && os.Args[1] == "mock-upstream" {
+		runMockUpstream(os.Args[2:])
+		return
+	}
+
 	// Configuration du serveur
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -22,14 +33,69 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Initialisation du router
+	// Sélection du fournisseur upstream
+	configureUpstreamURLs()
+	configureRetryPolicy()
+	configureFingerprints()
+	configureProxies()
+	configureIdentities()
+	configureBreakers()
+	configureModels()
+	configureResponseStore()
+	configureProvider()
+
+	router := newRouter()
+
+	log.Printf("🚀 DuckDuckGo Chat API démarrée sur le port %s", port)
+	log.Printf("📋 Documentation API disponible sur http://localhost:%s/", port)
+
+	// Le contexte de base est annulé à l'arrêt, ce qui interrompt les appels upstream en cours
+	baseCtx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        ":" + port,
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("❌ Erreur de démarrage du serveur:", err)
+		}
+	}()
+
+	// Arrêt propre: les requêtes en cours disposent de SHUTDOWN_TIMEOUT (défaut 10s)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	timeout := 10 * time.Second
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			timeout = d
+		}
+	}
+	log.Printf("🛑 Arrêt du serveur (délai de grâce %s)...", timeout)
+
+	ctx, stop := context.WithTimeout(context.Background(), timeout)
+	defer stop()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("⚠️ Requêtes interrompues à l'arrêt: %v", err)
+		cancel()
+		server.Close()
+	}
+	cancel()
+	log.Printf("👋 Serveur arrêté")
+}
+
+// Router de l'API: CORS, routes OpenAI, Anthropic, Ollama et administration
+func newRouter() *gin.Engine {
 	router := gin.Default()
 
 	// Configuration CORS
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Api-Key, Anthropic-Version")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -39,15 +105,37 @@ func main() {
 		c.Next()
 	})
 
-	// Routes de l'API
-	api := router.Group("/v1")
+	// Routes de l'API (les requêtes de chat sont rejetées d'emblée tant qu'un
+	// disjoncteur upstream est ouvert)
+	api := router.Group("/v1", circuitGuard())
 	{
 		// Routes essentielles du chat IA
 		api.GET("/health", HealthCheck)
 		api.GET("/models", GetModels)
+		api.GET("/models/*id", GetModel)
 		api.POST("/chat/completions", ChatHandler)
+		api.POST("/completions", CompletionsHandler)
+		api.POST("/responses", ResponsesHandler)
+		api.GET("/responses/:id", GetResponseHandler)
 		api.POST("/chat/stream", StreamChatHandler)
 		api.DELETE("/chat/clear", ClearChatHandler)
+
+		// Compatibilité avec l'API Messages d'Anthropic
+		api.POST("/messages", MessagesHandler)
+
+		// Administration (ADMIN_TOKEN)
+		admin := api.Group("/admin", adminAuth())
+		admin.GET("/identities", AdminIdentitiesHandler)
+		admin.POST("/models/reload", AdminReloadModelsHandler)
+	}
+
+	// Compatibilité avec l'API Ollama
+	ollama := router.Group("/api", circuitGuard())
+	{
+		ollama.GET("/tags", OllamaTagsHandler)
+		ollama.GET("/version", OllamaVersionHandler)
+		ollama.POST("/chat", OllamaChatHandler)
+		ollama.POST("/generate", OllamaGenerateHandler)
 	}
 
 	// Route racine pour information
@@ -57,19 +145,24 @@ func main() {
 			"version":     "1.0.0",
 			"description": "API minimaliste pour DuckDuckGo Chat IA",
 			"endpoints": gin.H{
-				"health":      "GET /v1/health",
-				"models":      "GET /v1/models",
-				"chat":        "POST /v1/chat/completions",
-				"chat_stream": "POST /v1/chat/stream",
-				"clear":       "DELETE /v1/chat/clear",
+				"health":          "GET /v1/health",
+				"models":          "GET /v1/models",
+				"model":           "GET /v1/models/{id}",
+				"chat":            "POST /v1/chat/completions",
+				"completions":     "POST /v1/completions",
+				"responses":       "POST /v1/responses",
+				"response":        "GET /v1/responses/{id}",
+				"chat_stream":     "POST /v1/chat/stream",
+				"clear":           "DELETE /v1/chat/clear",
+				"messages":        "POST /v1/messages",
+				"ollama_chat":     "POST /api/chat",
+				"ollama_generate": "POST /api/generate",
+				"ollama_tags":     "GET /api/tags",
+				"admin":           "GET /v1/admin/identities",
+				"admin_models":    "POST /v1/admin/models/reload",
 			},
 		})
 	})
 
-	log.Printf("🚀 DuckDuckGo Chat API démarrée sur le port %s", port)
-	log.Printf("📋 Documentation API disponible sur http://localhost:%s/", port)
-
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal("❌ Erreur de démarrage du serveur:", err)
-	}
+	return router
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Description d'un modèle du registre
+type ModelInfo struct {
+	ID            Model    `json:"id"`                    // identifiant exposé par l'API
+	UpstreamID    Model    `json:"upstream_id,omitempty"` // identifiant envoyé à DuckDuckGo (défaut: id)
+	Name          string   `json:"name"`
+	Description   string   `json:"description,omitempty"`
+	OwnedBy       string   `json:"owned_by"`
+	Aliases       []string `json:"aliases,omitempty"`
+	ContextLength int      `json:"context_length,omitempty"` // en tokens
+	Capabilities  []string `json:"capabilities,omitempty"`
+	Default       bool     `json:"default,omitempty"` // modèle utilisé sans "model"
+
+	// Modèle retiré: les requêtes sont redirigées vers Replacement s'il est renseigné
+	Deprecated  bool  `json:"deprecated,omitempty"`
+	Replacement Model `json:"replacement,omitempty"`
+}
+
+// Identifiant à envoyer à l'upstream
+func (m ModelInfo) upstreamID() Model {
+	if m.UpstreamID != "" {
+		return m.UpstreamID
+	}
+	return m.ID
+}
+
+// Modèles intégrés, utilisés sans MODELS_FILE
+var defaultModels = []ModelInfo{
+	{
+		ID:            "gpt-4o-mini",
+		Name:          "GPT-4o Mini",
+		Description:   "Modèle général rapide et équilibré",
+		OwnedBy:       "openai",
+		Aliases:       []string{"gpt4mini"},
+		ContextLength: 128000,
+		Capabilities:  []string{"chat", "tools"},
+		Default:       true,
+	},
+	{
+		ID:            "claude-3-haiku-20240307",
+		Name:          "Claude 3 Haiku",
+		Description:   "Excellente pour l'écriture créative et les explications",
+		OwnedBy:       "anthropic",
+		Aliases:       []string{"claude-3-haiku", "claude", "claude3"},
+		ContextLength: 200000,
+		Capabilities:  []string{"chat"},
+	},
+	{
+		ID:            "meta-llama/Llama-3.3-70B-Instruct-Turbo",
+		Name:          "Llama 3.3 70B",
+		Description:   "Spécialisé en programmation et tâches techniques",
+		OwnedBy:       "meta",
+		Aliases:       []string{"llama", "llama3"},
+		ContextLength: 128000,
+		Capabilities:  []string{"chat"},
+	},
+	{
+		ID:            "mistralai/Mistral-Small-24B-Instruct-2501",
+		Name:          "Mistral Small",
+		Description:   "Excellent pour l'analyse et le raisonnement",
+		OwnedBy:       "mistralai",
+		Aliases:       []string{"mixtral", "mistral"},
+		ContextLength: 32000,
+		Capabilities:  []string{"chat"},
+	},
+	{
+		ID:            "o4-mini",
+		Name:          "o4-mini",
+		Description:   "Très rapide pour les réponses courtes",
+		OwnedBy:       "openai",
+		Aliases:       []string{"o4mini"},
+		ContextLength: 200000,
+		Capabilities:  []string{"chat", "reasoning"},
+	},
+}
+
+// Registre des modèles: validation, alias, endpoints de modèles et tags Ollama.
+// Chargé depuis un fichier, il est rechargé lorsque le fichier change.
+type ModelRegistry struct {
+	mu      sync.RWMutex
+	models  []ModelInfo
+	path    string
+	modTime time.Time
+}
+
+var models = builtinModels()
+
+// Registre d'une liste de modèles, refusée si elle est incohérente (voir checkModels)
+func NewModelRegistry(list []ModelInfo) (*ModelRegistry, error) {
+	if err := checkModels(list); err != nil {
+		return nil, err
+	}
+	return &ModelRegistry{models: list}, nil
+}
+
+func builtinModels() *ModelRegistry {
+	registry, err := NewModelRegistry(defaultModels)
+	if err != nil {
+		log.Fatalf("❌ Modèles intégrés invalides: %v", err)
+	}
+	return registry
+}
+
+// Chargement du registre depuis les variables d'environnement: MODELS_FILE (liste
+// JSON de modèles) et MODELS_RELOAD_INTERVAL (vérification du fichier, défaut 30s,
+// 0 pour désactiver)
+func configureModels() {
+	path := os.Getenv("MODELS_FILE")
+	if path == "" {
+		log.Printf("🤖 %d modèles intégrés", len(defaultModels))
+		return
+	}
+
+	interval := 30 * time.Second
+	if value := os.Getenv("MODELS_RELOAD_INTERVAL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			log.Fatalf("❌ MODELS_RELOAD_INTERVAL invalide: %s", value)
+		}
+		interval = d
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("❌ Registre de modèles invalide: %v", err)
+	}
+	list, err := loadModels(path)
+	if err != nil {
+		log.Fatalf("❌ Registre de modèles invalide: %v", err)
+	}
+	registry, _ := NewModelRegistry(list) // liste déjà vérifiée par loadModels
+	registry.path = path
+	registry.modTime = info.ModTime()
+	models = registry
+	log.Printf("🤖 %d modèles chargés depuis %s", len(list), path)
+	if interval > 0 {
+		go registry.watch(interval)
+	}
+}
+
+// Relecture du fichier; en cas d'erreur le registre courant est conservé
+func (r *ModelRegistry) Reload() error {
+	if r.path == "" {
+		return fmt.Errorf("aucun fichier de modèles (MODELS_FILE)")
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	list, err := loadModels(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.models = list
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+	log.Printf("🤖 %d modèles chargés depuis %s", len(list), r.path)
+	return nil
+}
+
+// Rechargement à chaque modification du fichier
+func (r *ModelRegistry) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		info, err := os.Stat(r.path)
+		if err != nil {
+			continue
+		}
+		r.mu.RLock()
+		changed := !info.ModTime().Equal(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			// Pas de nouvelle tentative avant la prochaine modification du fichier
+			r.mu.Lock()
+			r.modTime = info.ModTime()
+			r.mu.Unlock()
+			log.Printf("⚠️ Registre de modèles non rechargé: %v", err)
+		}
+	}
+}
+
+func loadModels(path string) ([]ModelInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []ModelInfo
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := checkModels(list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return list, nil
+}
+
+// Cohérence du registre: identifiants et alias uniques (insensibles à la casse),
+// remplacements existants et non retirés, au plus un modèle par défaut (non retiré)
+// et au moins un modèle utilisable
+func checkModels(list []ModelInfo) error {
+	if len(list) == 0 {
+		return fmt.Errorf("aucun modèle")
+	}
+
+	names := make(map[string]Model)
+	ids := make(map[Model]ModelInfo)
+	defaults, available := 0, 0
+	for i, info := range list {
+		if info.ID == "" || info.Name == "" {
+			return fmt.Errorf("modèle %d sans id ou name", i)
+		}
+		for _, name := range append([]string{string(info.ID)}, info.Aliases...) {
+			key := strings.ToLower(name)
+			if other, exists := names[key]; exists {
+				return fmt.Errorf("%s déclaré par %s et %s", name, other, info.ID)
+			}
+			names[key] = info.ID
+		}
+		ids[info.ID] = info
+		if info.Default {
+			if info.Deprecated {
+				return fmt.Errorf("%s: modèle par défaut retiré", info.ID)
+			}
+			defaults++
+		}
+		if !info.Deprecated {
+			available++
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("plusieurs modèles par défaut")
+	}
+	if available == 0 {
+		return fmt.Errorf("aucun modèle non retiré")
+	}
+	for _, info := range list {
+		if info.Replacement == "" {
+			continue
+		}
+		replacement, exists := ids[info.Replacement]
+		if !exists {
+			return fmt.Errorf("%s: remplacement inconnu %s", info.ID, info.Replacement)
+		}
+		if replacement.Deprecated {
+			return fmt.Errorf("%s: remplacement %s lui-même retiré", info.ID, info.Replacement)
+		}
+	}
+	return nil
+}
+
+// Copie de la liste des modèles
+func (r *ModelRegistry) List() []ModelInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]ModelInfo{}, r.models...)
+}
+
+// Modèle par identifiant ou alias (insensible à la casse)
+func (r *ModelRegistry) Lookup(name string) (ModelInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, info := range r.models {
+		if strings.EqualFold(string(info.ID), name) {
+			return info, true
+		}
+		for _, alias := range info.Aliases {
+			if strings.EqualFold(alias, name) {
+				return info, true
+			}
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// Modèle à utiliser pour un nom (identifiant ou alias): le modèle par défaut pour un
+// nom vide, le remplaçant d'un modèle retiré (redirected) ou une erreur s'il n'en a pas
+func (r *ModelRegistry) Resolve(name string) (model Model, redirected bool, err error) {
+	if name == "" {
+		return r.Default(), false, nil
+	}
+
+	info, found := r.Lookup(name)
+	if !found {
+		return "", false, fmt.Errorf("modèle non supporté: %s", name)
+	}
+	if info.Deprecated {
+		if info.Replacement == "" {
+			return "", false, fmt.Errorf("modèle retiré: %s", name)
+		}
+		return info.Replacement, true, nil
+	}
+	return info.ID, false, nil
+}
+
+// Modèle par défaut: celui marqué default, à défaut le premier non retiré (il en
+// existe toujours un, voir checkModels)
+func (r *ModelRegistry) Default() Model {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, info := range r.models {
+		if info.Default {
+			return info.ID
+		}
+	}
+	for _, info := range r.models {
+		if !info.Deprecated {
+			return info.ID
+		}
+	}
+	return ""
+}
+
+// Identifiant upstream d'un modèle du registre
+func (r *ModelRegistry) UpstreamID(model Model) Model {
+	if info, found := r.Lookup(string(model)); found {
+		return info.upstreamID()
+	}
+	return model
+}
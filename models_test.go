@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewModelRegistryRejectsInvalidLists(t *testing.T) {
+	cases := map[string][]ModelInfo{
+		"vide":                 nil,
+		"alias en double":      {{ID: "a", Name: "A", Aliases: []string{"x"}}, {ID: "b", Name: "B", Aliases: []string{"X"}}},
+		"remplacement inconnu": {{ID: "a", Name: "A", Deprecated: true, Replacement: "b"}},
+		"tous retirés":         {{ID: "a", Name: "A", Deprecated: true}},
+		"défaut retiré":        {{ID: "a", Name: "A", Deprecated: true, Default: true}, {ID: "b", Name: "B"}},
+		"deux défauts":         {{ID: "a", Name: "A", Default: true}, {ID: "b", Name: "B", Default: true}},
+	}
+	for name, list := range cases {
+		if _, err := NewModelRegistry(list); err == nil {
+			t.Errorf("%s: liste acceptée", name)
+		}
+	}
+}
+
+func TestModelRegistryResolve(t *testing.T) {
+	registry, err := NewModelRegistry([]ModelInfo{
+		{ID: "old", Name: "Old", Aliases: []string{"legacy"}, Deprecated: true, Replacement: "new"},
+		{ID: "gone", Name: "Gone", Deprecated: true},
+		{ID: "new", Name: "New", UpstreamID: "upstream-new"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model := registry.Default(); model != "new" {
+		t.Errorf("modèle par défaut %q, new attendu", model)
+	}
+	if model, redirected, err := registry.Resolve("LEGACY"); err != nil || !redirected || model != "new" {
+		t.Errorf("alias retiré résolu en %q (%v, %v)", model, redirected, err)
+	}
+	if _, _, err := registry.Resolve("gone"); err == nil {
+		t.Error("modèle retiré sans remplacement accepté")
+	}
+	if _, _, err := registry.Resolve("inconnu"); err == nil {
+		t.Error("modèle inconnu accepté")
+	}
+	if upstream := registry.UpstreamID("new"); upstream != "upstream-new" {
+		t.Errorf("identifiant upstream %q", upstream)
+	}
+}
+
+func TestModelRegistryReloadKeepsModelsOnInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	os.WriteFile(path, []byte(`[{"id": "a", "name": "A"}]`), 0o644)
+	list, err := loadModels(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, _ := NewModelRegistry(list)
+	registry.path = path
+
+	os.WriteFile(path, []byte(`[{"id": "b", "name": "B", "default": true}, {"id": "c", "name": "C", "default": true}]`), 0o644)
+	if err := registry.Reload(); err == nil {
+		t.Fatal("fichier invalide accepté")
+	}
+	if _, found := registry.Lookup("a"); !found {
+		t.Fatal("registre courant perdu")
+	}
+
+	os.WriteFile(path, []byte(`[{"id": "b", "name": "B"}]`), 0o644)
+	if err := registry.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := registry.Lookup("b"); !found {
+		t.Fatal("registre non rechargé")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Structures de l'API Ollama (/api/chat, /api/generate, /api/tags)
+type OllamaChatRequest struct {
+	Model    string        `json:"model" binding:"required"`
+	Messages []Message     `json:"messages"`
+	Stream   *bool         `json:"stream,omitempty"`
+	Options  OllamaOptions `json:"options,omitempty"`
+}
+
+type OllamaGenerateRequest struct {
+	Model   string        `json:"model" binding:"required"`
+	Prompt  string        `json:"prompt"`
+	System  string        `json:"system,omitempty"`
+	Stream  *bool         `json:"stream,omitempty"`
+	Options OllamaOptions `json:"options,omitempty"`
+}
+
+// Options Ollama prises en charge (les autres sont ignorées)
+type OllamaOptions struct {
+	NumPredict int      `json:"num_predict,omitempty"`
+	Stop       []string `json:"stop,omitempty"`
+}
+
+func (o OllamaOptions) streamOptions() StreamOptions {
+	return StreamOptions{Stop: o.Stop, MaxTokens: o.NumPredict}
+}
+
+// Ligne NDJSON commune à /api/chat (Message) et /api/generate (Response)
+type OllamaResponse struct {
+	Model              string   `json:"model"`
+	CreatedAt          string   `json:"created_at"`
+	Message            *Message `json:"message,omitempty"`
+	Response           *string  `json:"response,omitempty"`
+	Done               bool     `json:"done"`
+	DoneReason         string   `json:"done_reason,omitempty"`
+	TotalDuration      int64    `json:"total_duration,omitempty"`
+	LoadDuration       int64    `json:"load_duration,omitempty"`
+	PromptEvalCount    int      `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64    `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int      `json:"eval_count,omitempty"`
+	EvalDuration       int64    `json:"eval_duration,omitempty"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaModelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// Résolution d'un nom Ollama ("llama:latest") vers un modèle DuckDuckGo
+func validateOllamaModel(name string) (Model, error) {
+	name, _, _ = strings.Cut(name, ":")
+	return validateModel(name)
+}
+
+// Handler pour lister les modèles au format Ollama (hors modèles retirés)
+func OllamaTagsHandler(c *gin.Context) {
+	modifiedAt := time.UnixMilli(getCurrentTimestamp()).UTC().Format(time.RFC3339)
+
+	tags := []OllamaModel{}
+	for _, info := range models.List() {
+		if info.Deprecated {
+			continue
+		}
+		digest := sha256.Sum256([]byte(info.ID))
+		tags = append(tags, OllamaModel{
+			Name:       string(info.ID) + ":latest",
+			Model:      string(info.ID) + ":latest",
+			ModifiedAt: modifiedAt,
+			Digest:     hex.EncodeToString(digest[:]),
+			Details: OllamaModelDetails{
+				Format:   "remote",
+				Family:   info.OwnedBy,
+				Families: []string{info.OwnedBy},
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": tags})
+}
+
+// Handler de version, utilisé par les clients Ollama pour détecter le serveur
+func OllamaVersionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"version": "0.5.0"})
+}
+
+// Handler compatible avec /api/chat d'Ollama
+func OllamaChatHandler(c *gin.Context) {
+	var req OllamaChatRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Requête invalide: %v", err)})
+		return
+	}
+
+	ollamaExchange(c, req.Model, req.Messages, req.Stream, req.Options.streamOptions(), func(line *OllamaResponse, text string) {
+		line.Message = &Message{Role: "assistant", Content: text}
+	})
+}
+
+// Handler compatible avec /api/generate d'Ollama
+func OllamaGenerateHandler(c *gin.Context) {
+	var req OllamaGenerateRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Requête invalide: %v", err)})
+		return
+	}
+
+	var messages []Message
+	if req.System != "" {
+		messages = append(messages, Message{Role: "system", Content: req.System})
+	}
+	messages = append(messages, Message{Role: "user", Content: req.Prompt})
+
+	ollamaExchange(c, req.Model, messages, req.Stream, req.Options.streamOptions(), func(line *OllamaResponse, text string) {
+		line.Response = &text
+	})
+}
+
+// Échange commun à /api/chat et /api/generate: stream NDJSON (par défaut) ou réponse unique.
+// setText place le texte dans le champ propre à chaque endpoint.
+func ollamaExchange(c *gin.Context, modelName string, messages []Message, streamFlag *bool, opts StreamOptions, setText func(*OllamaResponse, string)) {
+	start := time.Now()
+
+	// Validation du modèle
+	model, err := validateOllamaModel(modelName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Envoyer le message
+	prompt := buildContent(messages)
+	session, events, err := sendEphemeral(c, model, prompt, opts)
+	if err != nil {
+		status, _ := errorStatus(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	loaded := time.Now()
+
+	newLine := func(text string) *OllamaResponse {
+		line := &OllamaResponse{
+			Model:     modelName,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		setText(line, text)
+		return line
+	}
+
+	// Ollama streame par défaut, sauf "stream": false explicite
+	streaming := streamFlag == nil || *streamFlag
+	if streaming {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+
+	var answer strings.Builder
+	err = drainStream(events, func(text string) {
+		answer.WriteString(text)
+		if streaming {
+			writeNDJSON(c, newLine(text))
+		}
+	})
+	if err != nil {
+		if streaming {
+			writeNDJSON(c, gin.H{"error": fmt.Sprintf("Erreur de stream: %v", err)})
+		} else {
+			setRetryAfter(c, err)
+			status, _ := errorStatus(err)
+			c.JSON(status, gin.H{"error": fmt.Sprintf("Erreur de stream: %v", err)})
+		}
+		return
+	}
+
+	// Ligne finale avec les statistiques (durées en nanosecondes)
+	final := newLine("")
+	if !streaming {
+		final = newLine(answer.String())
+	}
+	final.Done = true
+	final.DoneReason = session.LastResult().FinishReason
+	final.TotalDuration = time.Since(start).Nanoseconds()
+	final.LoadDuration = loaded.Sub(start).Nanoseconds()
+	final.PromptEvalCount = estimateTokens(prompt)
+	final.EvalCount = estimateTokens(answer.String())
+	final.EvalDuration = time.Since(loaded).Nanoseconds()
+
+	if streaming {
+		writeNDJSON(c, final)
+		return
+	}
+	setUpstreamMessageHeader(c, session)
+	c.JSON(http.StatusOK, final)
+}
+
+// Écriture d'une ligne NDJSON
+func writeNDJSON(c *gin.Context, v interface{}) {
+	data, _ := json.Marshal(v)
+	c.Writer.Write(append(data, '\n'))
+	c.Writer.Flush()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Fournisseur de conversations upstream. Les handlers ne dépendent que de cette
+// interface: DuckDuckGo en production, un backend scripté hors ligne.
+type Provider interface {
+	Name() string
+	NewConversation(model Model) (Conversation, error)
+}
+
+// Conversation upstream avec son historique
+type Conversation interface {
+	CurrentModel() Model
+	SetModel(model Model)
+
+	// Outils de recherche utilisés par défaut pour les envois de la conversation
+	CurrentTools() ToolChoice
+	SetTools(tools ToolChoice)
+
+	// Envoi d'un message; la réponse est lue sur le flux d'événements renvoyé.
+	// Une erreur immédiate signifie qu'aucune réponse n'a pu être obtenue.
+	// L'annulation de ctx interrompt l'envoi comme la lecture de la réponse.
+	Send(ctx context.Context, content string, opts StreamOptions) (chan UpstreamEvent, error)
+
+	// Issue de la dernière réponse, valable une fois EventDone reçu
+	// (Attempts est renseigné dès le retour de Send, y compris en cas d'erreur)
+	LastResult() StreamResult
+
+	Clear()
+}
+
+// Fournisseur utilisé par les handlers
+var upstream Provider = &DuckDuckGoProvider{}
+
+// Sélection du fournisseur depuis les variables d'environnement (PROVIDER)
+func configureProvider() {
+	switch os.Getenv("PROVIDER") {
+	case "", "duckduckgo":
+		upstream = &DuckDuckGoProvider{}
+		startVQDPool()
+	case "scripted":
+		upstream = NewScriptedProvider()
+	default:
+		log.Fatalf("❌ Fournisseur inconnu: %s", os.Getenv("PROVIDER"))
+	}
+	log.Printf("🔌 Fournisseur upstream: %s (%s)", upstream.Name(), ChatURL)
+}
+
+// Fournisseur DuckDuckGo, adossé à ChatSession
+type DuckDuckGoProvider struct{}
+
+func (p *DuckDuckGoProvider) Name() string {
+	return "duckduckgo"
+}
+
+func (p *DuckDuckGoProvider) NewConversation(model Model) (Conversation, error) {
+	session := NewChatSession(model)
+	if session == nil {
+		return nil, fmt.Errorf("impossible d'obtenir le token VQD")
+	}
+	return session, nil
+}
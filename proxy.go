@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy sortant vers l'upstream (http, https, socks5 ou socks5h), avec son propre
+// transport pour réutiliser les connexions
+type UpstreamProxy struct {
+	URL       *url.URL
+	transport *http.Transport
+
+	// État de santé, protégé par le mutex du pool
+	failures    int       // erreurs réseau consécutives
+	bannedUntil time.Time // écarté jusqu'à cette date
+}
+
+// Nom affichable, sans les identifiants
+func (p *UpstreamProxy) String() string {
+	if p == nil {
+		return "direct"
+	}
+	return p.URL.Scheme + "://" + p.URL.Host
+}
+
+// Transport HTTP passant par le proxy (transport par défaut sans proxy)
+func (p *UpstreamProxy) Transport() http.RoundTripper {
+	if p == nil {
+		return http.DefaultTransport
+	}
+	return p.transport
+}
+
+// Ensemble des proxies sortants: tourniquet sur les proxies sains. Un proxy est
+// écarté pendant cooldown après un signal de bannissement (418, 429) ou après
+// maxFailures erreurs réseau consécutives.
+type ProxyPool struct {
+	mu          sync.Mutex
+	proxies     []*UpstreamProxy
+	next        int
+	cooldown    time.Duration
+	maxFailures int
+}
+
+// Pool utilisé pour le trafic upstream (nil = connexion directe)
+var proxies *ProxyPool
+
+func NewProxyPool(urls []string, cooldown time.Duration) (*ProxyPool, error) {
+	p := &ProxyPool{cooldown: cooldown, maxFailures: 3}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("proxy invalide %q: %v", raw, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("schéma de proxy non supporté: %s", raw)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("proxy sans hôte: %s", raw)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(u)
+		p.proxies = append(p.proxies, &UpstreamProxy{URL: u, transport: transport})
+	}
+	if len(p.proxies) == 0 {
+		return nil, fmt.Errorf("aucun proxy")
+	}
+	return p, nil
+}
+
+// Configuration depuis les variables d'environnement: PROXIES (URLs séparées par
+// des virgules) ou PROXIES_FILE (une URL par ligne, # pour les commentaires), et
+// PROXY_COOLDOWN (défaut 10m)
+func configureProxies() {
+	var urls []string
+	for _, raw := range strings.Split(os.Getenv("PROXIES"), ",") {
+		if raw = strings.TrimSpace(raw); raw != "" {
+			urls = append(urls, raw)
+		}
+	}
+	if path := os.Getenv("PROXIES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("❌ Fichier de proxies illisible: %v", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				urls = append(urls, line)
+			}
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	cooldown := 10 * time.Minute
+	if value := os.Getenv("PROXY_COOLDOWN"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			log.Fatalf("❌ PROXY_COOLDOWN invalide: %s", value)
+		}
+		cooldown = d
+	}
+
+	pool, err := NewProxyPool(urls, cooldown)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	proxies = pool
+	log.Printf("🌐 %d proxies sortants, écartés pendant %s après un bannissement", len(urls), cooldown)
+}
+
+// Proxy suivant (tourniquet), en évitant les proxies écartés tant qu'il en reste d'autres
+func (p *ProxyPool) Next() *UpstreamProxy {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for range p.proxies {
+		proxy := p.proxies[p.next%len(p.proxies)]
+		p.next++
+		if now.After(proxy.bannedUntil) {
+			return proxy
+		}
+	}
+	proxy := p.proxies[p.next%len(p.proxies)]
+	p.next++
+	return proxy
+}
+
+// Signal de bannissement de l'upstream (418, 429): le proxy est écarté
+func (p *ProxyPool) Ban(proxy *UpstreamProxy) {
+	if p == nil || proxy == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.bannedUntil = time.Now().Add(p.cooldown)
+	log.Printf("🌐 Proxy %s banni, écarté pendant %s", proxy, p.cooldown)
+}
+
+// Erreur réseau à travers le proxy
+func (p *ProxyPool) Failure(proxy *UpstreamProxy) {
+	if p == nil || proxy == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.failures++
+	if proxy.failures >= p.maxFailures {
+		proxy.failures = 0
+		proxy.bannedUntil = time.Now().Add(p.cooldown)
+		log.Printf("🌐 Proxy %s injoignable (%d erreurs), écarté pendant %s", proxy, p.maxFailures, p.cooldown)
+	}
+}
+
+// Requête aboutie à travers le proxy
+func (p *ProxyPool) Success(proxy *UpstreamProxy) {
+	if p == nil || proxy == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.failures = 0
+}
+
+// Nombre de proxies configurés (0 en connexion directe)
+func (p *ProxyPool) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.proxies)
+}
+
+func (p *ProxyPool) IsBanned(proxy *UpstreamProxy) bool {
+	if p == nil || proxy == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().Before(proxy.bannedUntil)
+}
+
+// État des proxies pour le health check
+func (p *ProxyPool) Status() []map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var status []map[string]string
+	for _, proxy := range p.proxies {
+		state := map[string]string{"proxy": proxy.String(), "state": "ok"}
+		if time.Now().Before(proxy.bannedUntil) {
+			state["state"] = "banned"
+			state["until"] = proxy.bannedUntil.UTC().Format(time.RFC3339)
+		} else if proxy.failures > 0 {
+			state["state"] = "degraded"
+			state["failures"] = strconv.Itoa(proxy.failures)
+		}
+		status = append(status, state)
+	}
+	return status
+}
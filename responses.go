@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Réponses déjà produites, pour le chaînage par previous_response_id et la relecture.
+// Les plus anciennes sont oubliées au-delà de size réponses ou après ttl. Une session
+// n'est gardée que tant qu'une réponse conservée ou une requête en cours y renvoie.
+type ResponseStore struct {
+	mu      sync.Mutex
+	entries map[string]*storedResponse
+	order   []string          // identifiants par ordre d'enregistrement
+	latest  map[string]string // session -> sa dernière réponse, poursuivable en place
+	refs    map[string]int    // session -> réponses conservées et requêtes en cours
+	size    int
+	ttl     time.Duration
+
+	// Appelée lorsqu'une session n'est plus référencée (removeSession par défaut)
+	release func(sessionID string)
+}
+
+// Réponse conservée avec la session DuckDuckGo qui l'a produite (vide pour une
+// conversation éphémère) et l'historique complet de la conversation jusqu'à elle
+type storedResponse struct {
+	SessionID string
+	Messages  []Message
+	Response  ResponseObject
+	StoredAt  time.Time
+}
+
+var responseStore = NewResponseStore(1000, time.Hour)
+
+func NewResponseStore(size int, ttl time.Duration) *ResponseStore {
+	return &ResponseStore{
+		entries: make(map[string]*storedResponse),
+		latest:  make(map[string]string),
+		refs:    make(map[string]int),
+		size:    size,
+		ttl:     ttl,
+		release: removeSession,
+	}
+}
+
+// Configuration depuis les variables d'environnement: RESPONSE_STORE_SIZE (réponses
+// conservées, défaut 1000) et RESPONSE_TTL (durée de conservation, défaut 1h)
+func configureResponseStore() {
+	size := 1000
+	if value := os.Getenv("RESPONSE_STORE_SIZE"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			log.Fatalf("❌ RESPONSE_STORE_SIZE invalide: %s", value)
+		}
+		size = n
+	}
+	ttl := time.Hour
+	if value := os.Getenv("RESPONSE_TTL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Fatalf("❌ RESPONSE_TTL invalide: %s", value)
+		}
+		ttl = d
+	}
+
+	responseStore = NewResponseStore(size, ttl)
+	log.Printf("🗃️ Réponses conservées: %d au plus, pendant %s", size, ttl)
+}
+
+// Oubli des réponses expirées ou en surnombre, les plus anciennes d'abord
+// (à appeler avec le mutex verrouillé)
+func (s *ResponseStore) evict(now time.Time) {
+	for len(s.order) > 0 {
+		id := s.order[0]
+		entry, exists := s.entries[id]
+		if exists && len(s.entries) <= s.size && now.Sub(entry.StoredAt) < s.ttl {
+			return
+		}
+		s.order = s.order[1:]
+		if exists {
+			delete(s.entries, id)
+			if s.latest[entry.SessionID] == id {
+				delete(s.latest, entry.SessionID)
+			}
+			s.unref(entry.SessionID)
+		}
+	}
+}
+
+// Fin d'une référence à une session; la dernière la libère (mutex verrouillé)
+func (s *ResponseStore) unref(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	s.refs[sessionID]--
+	if s.refs[sessionID] > 0 {
+		return
+	}
+	delete(s.refs, sessionID)
+	s.release(sessionID)
+}
+
+// Référence à une session pour la durée d'une requête (voir Release)
+func (s *ResponseStore) Hold(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs[sessionID]++
+}
+
+// Fin de la requête qui référençait la session (Hold ou Continue en place)
+func (s *ResponseStore) Release(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unref(sessionID)
+}
+
+func (s *ResponseStore) Get(id string) (storedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(time.Now())
+	entry, exists := s.entries[id]
+	if !exists {
+		return storedResponse{}, false
+	}
+	return *entry, true
+}
+
+// Reprise de la conversation après une réponse. Seule la dernière réponse d'une
+// session peut la poursuivre en place (inPlace); une réponse plus ancienne, déjà en
+// cours de poursuite ou issue d'une conversation éphémère ouvre une bifurcation qui
+// repart de son historique. Une poursuite en place retient la session jusqu'à Release.
+func (s *ResponseStore) Continue(id string) (previous storedResponse, inPlace bool, exists bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(time.Now())
+	entry, exists := s.entries[id]
+	if !exists {
+		return storedResponse{}, false, false
+	}
+	inPlace = entry.SessionID != "" && s.latest[entry.SessionID] == id
+	if inPlace {
+		// Réservée jusqu'à l'enregistrement de la réponse suivante
+		delete(s.latest, entry.SessionID)
+		s.refs[entry.SessionID]++
+	}
+	return *entry, inPlace, true
+}
+
+// Enregistrement d'une réponse, qui devient la dernière de sa session
+func (s *ResponseStore) Put(entry storedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.StoredAt = time.Now()
+	s.entries[entry.Response.ID] = &entry
+	s.order = append(s.order, entry.Response.ID)
+	if entry.SessionID != "" {
+		s.latest[entry.SessionID] = entry.Response.ID
+		s.refs[entry.SessionID]++
+	}
+	s.evict(entry.StoredAt)
+}
+
+// Structures de l'API Responses d'OpenAI (/v1/responses)
+type ResponsesRequest struct {
+	Model              string         `json:"model,omitempty"`
+	Input              ResponsesInput `json:"input" binding:"required"`
+	Instructions       string         `json:"instructions,omitempty"`
+	PreviousResponseID string         `json:"previous_response_id,omitempty"`
+	Stream             bool           `json:"stream,omitempty"`
+	MaxOutputTokens    int            `json:"max_output_tokens,omitempty"`
+}
+
+// Entrée Responses: texte simple ou liste de messages (contenu texte ou parties typées)
+type ResponsesInput []Message
+
+type ResponseObject struct {
+	ID                 string               `json:"id"`
+	Object             string               `json:"object"`
+	CreatedAt          int64                `json:"created_at"`
+	Status             string               `json:"status"`
+	Model              string               `json:"model"`
+	Instructions       interface{}          `json:"instructions"`
+	PreviousResponseID interface{}          `json:"previous_response_id"`
+	Output             []ResponseOutputItem `json:"output"`
+	IncompleteDetails  interface{}          `json:"incomplete_details"`
+	Error              interface{}          `json:"error"`
+	Usage              *ResponseUsage       `json:"usage"`
+}
+
+type ResponseOutputItem struct {
+	Type    string               `json:"type"`
+	ID      string               `json:"id"`
+	Status  string               `json:"status"`
+	Role    string               `json:"role"`
+	Content []ResponseOutputText `json:"content"`
+}
+
+type ResponseOutputText struct {
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Annotations []interface{} `json:"annotations"`
+}
+
+type ResponseUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+func (in *ResponsesInput) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*in = ResponsesInput{{Role: "user", Content: text}}
+		return nil
+	}
+
+	var items []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("input invalide: texte ou liste de messages attendu")
+	}
+
+	var messages ResponsesInput
+	for _, item := range items {
+		var content string
+		if err := json.Unmarshal(item.Content, &content); err != nil {
+			// Parties typées: seules input_text et output_text sont transmises
+			var parts []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(item.Content, &parts); err != nil {
+				return fmt.Errorf("input invalide: contenu de message non supporté")
+			}
+			var texts []string
+			for _, part := range parts {
+				if part.Type == "input_text" || part.Type == "output_text" {
+					texts = append(texts, part.Text)
+				}
+			}
+			content = strings.Join(texts, "\n")
+		}
+
+		role := item.Role
+		if role == "developer" {
+			role = "system"
+		}
+		messages = append(messages, Message{Role: role, Content: content})
+	}
+	*in = messages
+	return nil
+}
+
+// Émetteur des événements typés du streaming Responses (numérotés)
+type responseEventWriter struct {
+	c        *gin.Context
+	sequence int
+}
+
+func (w *responseEventWriter) emit(eventType string, fields gin.H) {
+	fields["type"] = eventType
+	fields["sequence_number"] = w.sequence
+	w.sequence++
+	writeSSEEvent(w.c, eventType, fields)
+}
+
+// Handler compatible avec l'API Responses d'OpenAI
+func ResponsesHandler(c *gin.Context) {
+	var req ResponsesRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   fmt.Sprintf("Requête invalide: %v", err),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+
+	// Validation du modèle
+	model, err := validateModel(req.Model)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   err.Error(),
+			Code:    400,
+			Success: false,
+		})
+		return
+	}
+
+	// Chaînage: la dernière réponse d'une conversation DuckDuckGo la poursuit; une
+	// réponse plus ancienne repart de son historique sur une conversation éphémère
+	sessionID := ""
+	var history []Message
+	fork := false
+	if req.PreviousResponseID != "" {
+		previous, inPlace, exists := responseStore.Continue(req.PreviousResponseID)
+		if !exists {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   fmt.Sprintf("Réponse précédente non trouvée: %s", req.PreviousResponseID),
+				Code:    404,
+				Success: false,
+			})
+			return
+		}
+		if inPlace {
+			sessionID = previous.SessionID
+			defer responseStore.Release(sessionID)
+		}
+		history = previous.Messages
+		fork = !inPlace
+	}
+
+	var session Conversation
+	if fork {
+		// Tout l'historique est renvoyé: rien à garder parmi les sessions
+		session, err = upstream.NewConversation(model)
+	} else if session = getOrCreateSession(sessionID, model); session != nil && sessionID == "" {
+		sessionID = lookupSessionID(sessionID, session)
+		responseStore.Hold(sessionID)
+		defer responseStore.Release(sessionID)
+	}
+	if session == nil {
+		status := http.StatusInternalServerError
+		if err != nil {
+			setRetryAfter(c, err)
+			status, _ = errorStatus(err)
+		}
+		c.JSON(status, ErrorResponse{
+			Error:   "Impossible de créer la session de chat",
+			Code:    status,
+			Success: false,
+		})
+		return
+	}
+	if sessionID != "" {
+		c.Header("X-Session-ID", sessionID)
+	}
+
+	// En place, seule la nouvelle entrée est envoyée: l'historique est déjà dans la
+	// session. Une bifurcation envoie l'historique de la réponse reprise.
+	messages := []Message(req.Input)
+	if req.Instructions != "" {
+		messages = append([]Message{{Role: "system", Content: req.Instructions}}, messages...)
+	}
+	prompt := buildContent(messages)
+	if fork {
+		prompt = buildContent(append(append([]Message{}, history...), messages...))
+	}
+
+	stream, err := session.Send(c.Request.Context(), prompt, StreamOptions{MaxTokens: req.MaxOutputTokens})
+	setAttemptsHeader(c, session)
+	if err != nil {
+		setRetryAfter(c, err)
+		status, _ := errorStatus(err)
+		c.JSON(status, ErrorResponse{
+			Error:   fmt.Sprintf("Erreur de chat: %v", err),
+			Code:    status,
+			Success: false,
+		})
+		return
+	}
+
+	response := ResponseObject{
+		ID:        generateID("resp_"),
+		Object:    "response",
+		CreatedAt: time.Now().Unix(),
+		Status:    "in_progress",
+		Model:     string(session.CurrentModel()),
+		Output:    []ResponseOutputItem{},
+	}
+	if req.Instructions != "" {
+		response.Instructions = req.Instructions
+	}
+	if req.PreviousResponseID != "" {
+		response.PreviousResponseID = req.PreviousResponseID
+	}
+	item := ResponseOutputItem{
+		Type:    "message",
+		ID:      generateID("msg_"),
+		Status:  "in_progress",
+		Role:    "assistant",
+		Content: []ResponseOutputText{},
+	}
+
+	var events *responseEventWriter
+	if req.Stream {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+
+		events = &responseEventWriter{c: c}
+		events.emit("response.created", gin.H{"response": response})
+		events.emit("response.in_progress", gin.H{"response": response})
+		events.emit("response.output_item.added", gin.H{"output_index": 0, "item": item})
+		events.emit("response.content_part.added", gin.H{
+			"item_id":       item.ID,
+			"output_index":  0,
+			"content_index": 0,
+			"part":          ResponseOutputText{Type: "output_text", Annotations: []interface{}{}},
+		})
+	}
+
+	var answer strings.Builder
+	err = drainStream(stream, func(text string) {
+		answer.WriteString(text)
+		if events != nil {
+			events.emit("response.output_text.delta", gin.H{
+				"item_id":       item.ID,
+				"output_index":  0,
+				"content_index": 0,
+				"delta":         text,
+			})
+		}
+	})
+	if err != nil {
+		status, code := errorStatus(err)
+		if events == nil {
+			c.JSON(status, ErrorResponse{
+				Error:   fmt.Sprintf("Erreur de stream: %v", err),
+				Code:    status,
+				Success: false,
+			})
+			return
+		}
+		response.Status = "failed"
+		response.Error = gin.H{"code": code, "message": fmt.Sprintf("Erreur de stream: %v", err)}
+		events.emit("response.failed", gin.H{"response": response})
+		return
+	}
+
+	// Réponse finale
+	part := ResponseOutputText{Type: "output_text", Text: answer.String(), Annotations: []interface{}{}}
+	item.Status = "completed"
+	item.Content = []ResponseOutputText{part}
+	response.Status = "completed"
+	if session.LastResult().FinishReason == "length" {
+		response.Status = "incomplete"
+		response.IncompleteDetails = gin.H{"reason": "max_output_tokens"}
+	}
+	response.Output = []ResponseOutputItem{item}
+	response.Model = respondingModel(session)
+	usage := newUsage(prompt, part.Text)
+	response.Usage = &ResponseUsage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+		TotalTokens:  usage.TotalTokens,
+	}
+
+	responseStore.Put(storedResponse{
+		SessionID: sessionID,
+		Messages:  append(append(append([]Message{}, history...), messages...), Message{Role: "assistant", Content: part.Text}),
+		Response:  response,
+	})
+
+	if events == nil {
+		setUpstreamMessageHeader(c, session)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	events.emit("response.output_text.done", gin.H{
+		"item_id":       item.ID,
+		"output_index":  0,
+		"content_index": 0,
+		"text":          part.Text,
+	})
+	events.emit("response.content_part.done", gin.H{
+		"item_id":       item.ID,
+		"output_index":  0,
+		"content_index": 0,
+		"part":          part,
+	})
+	events.emit("response.output_item.done", gin.H{"output_index": 0, "item": item})
+	if response.Status == "incomplete" {
+		events.emit("response.incomplete", gin.H{"response": response})
+		return
+	}
+	events.emit("response.completed", gin.H{"response": response})
+}
+
+// Handler pour relire une réponse déjà produite
+func GetResponseHandler(c *gin.Context) {
+	stored, exists := responseStore.Get(c.Param("id"))
+
+	if !exists {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "Réponse non trouvée",
+			Code:    404,
+			Success: false,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, stored.Response)
+}
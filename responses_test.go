@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func storedFor(session, id string) storedResponse {
+	return storedResponse{SessionID: session, Response: ResponseObject{ID: id}}
+}
+
+func TestResponseStoreEvictsOldestAndExpired(t *testing.T) {
+	store := NewResponseStore(2, time.Hour)
+	store.Put(storedFor("s1", "r1"))
+	store.Put(storedFor("s1", "r2"))
+	store.Put(storedFor("s2", "r3"))
+
+	if _, exists := store.Get("r1"); exists {
+		t.Error("r1 conservée au-delà de la taille maximale")
+	}
+	if _, exists := store.Get("r3"); !exists {
+		t.Error("r3 oubliée")
+	}
+
+	store.ttl = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if _, exists := store.Get("r3"); exists {
+		t.Error("r3 conservée après expiration")
+	}
+	if len(store.entries) != 0 || len(store.order) != 0 || len(store.latest) != 0 {
+		t.Errorf("entrées restantes: %d / %d / %d", len(store.entries), len(store.order), len(store.latest))
+	}
+}
+
+func TestResponseStoreContinueForksFromOlderResponses(t *testing.T) {
+	store := NewResponseStore(10, time.Hour)
+	store.Put(storedFor("s1", "r1"))
+	store.Put(storedFor("s1", "r2"))
+
+	if _, inPlace, exists := store.Continue("r1"); !exists || inPlace {
+		t.Errorf("r1: exists=%v inPlace=%v, bifurcation attendue", exists, inPlace)
+	}
+	if _, inPlace, _ := store.Continue("r2"); !inPlace {
+		t.Error("r2, dernière réponse de s1, doit poursuivre la session")
+	}
+	// Poursuite déjà réservée: une seconde reprise concurrente bifurque
+	if _, inPlace, _ := store.Continue("r2"); inPlace {
+		t.Error("r2 poursuivie deux fois en place")
+	}
+	if _, _, exists := store.Continue("inconnue"); exists {
+		t.Error("réponse inconnue trouvée")
+	}
+}
+
+func TestResponseStoreReleasesUnreferencedSessions(t *testing.T) {
+	store := NewResponseStore(2, time.Hour)
+	var released []string
+	store.release = func(sessionID string) { released = append(released, sessionID) }
+
+	store.Hold("s1")
+	store.Put(storedFor("s1", "r1"))
+	store.Release("s1")
+	store.Put(storedFor("s2", "r2"))
+	store.Put(storedFor("", "r3")) // conversation éphémère
+	if len(released) != 1 || released[0] != "s1" {
+		t.Fatalf("sessions libérées: %v", released)
+	}
+
+	// Poursuite en place de r2 pendant que sa réponse est évincée: la session reste
+	// retenue jusqu'à la fin de la requête
+	if _, inPlace, _ := store.Continue("r2"); !inPlace {
+		t.Fatal("r2 doit poursuivre s2")
+	}
+	store.Put(storedFor("", "r4"))
+	store.Put(storedFor("", "r5"))
+	if len(released) != 1 {
+		t.Fatalf("s2 libérée pendant sa poursuite: %v", released)
+	}
+	store.Release("s2")
+	if len(released) != 2 || released[1] != "s2" || len(store.refs) != 0 {
+		t.Errorf("sessions libérées: %v, références: %v", released, store.refs)
+	}
+
+	// Une réponse éphémère ne se poursuit jamais en place
+	if _, inPlace, exists := store.Continue("r5"); !exists || inPlace {
+		t.Errorf("r5: exists=%v inPlace=%v", exists, inPlace)
+	}
+}
+
+func TestResponsesHandlerBoundsSessions(t *testing.T) {
+	router, _ := newScriptedRouter(t)
+	previous := responseStore
+	responseStore = NewResponseStore(2, time.Hour)
+	t.Cleanup(func() { responseStore = previous })
+	sessionMutex.RLock()
+	before := len(chatSessions)
+	sessionMutex.RUnlock()
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		recorder := postJSON(router, "/v1/responses", `{"input": "Bonjour"}`)
+		var response ResponseObject
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+			t.Fatalf("statut %d: %s", recorder.Code, recorder.Body)
+		}
+		ids = append(ids, response.ID)
+	}
+	sessionMutex.RLock()
+	after := len(chatSessions)
+	sessionMutex.RUnlock()
+	if after-before != 2 {
+		t.Errorf("%d sessions ajoutées pour 2 réponses conservées", after-before)
+	}
+
+	// Bifurcation depuis une réponse dépassée: conversation éphémère, sans X-Session-ID
+	postJSON(router, "/v1/responses", `{"input": "Suite", "previous_response_id": "`+ids[4]+`"}`)
+	recorder := postJSON(router, "/v1/responses", `{"input": "Autre suite", "previous_response_id": "`+ids[4]+`"}`)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("X-Session-ID") != "" {
+		t.Errorf("bifurcation: statut %d, session %q", recorder.Code, recorder.Header().Get("X-Session-ID"))
+	}
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	if len(chatSessions)-before > 2 {
+		t.Errorf("%d sessions après bifurcation", len(chatSessions)-before)
+	}
+}
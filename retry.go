@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Politique de retry des requêtes de chat upstream: backoff exponentiel avec
+// jitter, nombre d'essais maximal et délai global
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Deadline    time.Duration
+}
+
+var retryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    8 * time.Second,
+	Deadline:    30 * time.Second,
+}
+
+// Lecture de la politique depuis les variables d'environnement:
+// RETRY_MAX_ATTEMPTS, RETRY_BASE_DELAY, RETRY_MAX_DELAY et RETRY_DEADLINE
+func configureRetryPolicy() {
+	if value := os.Getenv("RETRY_MAX_ATTEMPTS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			log.Fatalf("❌ RETRY_MAX_ATTEMPTS invalide: %s", value)
+		}
+		retryPolicy.MaxAttempts = n
+	}
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"RETRY_BASE_DELAY", &retryPolicy.BaseDelay},
+		{"RETRY_MAX_DELAY", &retryPolicy.MaxDelay},
+		{"RETRY_DEADLINE", &retryPolicy.Deadline},
+	}
+	for _, setting := range durations {
+		if value := os.Getenv(setting.name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				log.Fatalf("❌ %s invalide: %s", setting.name, value)
+			}
+			*setting.value = d
+		}
+	}
+}
+
+// Attente avant l'essai suivant (attempt = numéro de l'essai échoué, à partir de 1):
+// BaseDelay * 2^(attempt-1), plafonné à MaxDelay, dont une moitié aléatoire
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Réponse upstream en erreur
+type UpstreamError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("erreur %d: %s. Body: %s", e.StatusCode, e.Status, e.Body)
+}
+
+// Type d'erreur déclaré dans le corps ({"action":"error","type":"ERR_..."})
+func (e *UpstreamError) errType() string {
+	var body struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal([]byte(e.Body), &body)
+	return body.Type
+}
+
+func (e *UpstreamError) class() upstreamErrorClass {
+	return classifyUpstreamError(e.errType(), e.StatusCode)
+}
+
+// Erreurs passagères: anti-bot, limitation de débit, token VQD refusé, panne upstream
+func (e *UpstreamError) Retryable() bool {
+	return e.class().Transient
+}
+
+// Le token VQD doit être renouvelé avant l'essai suivant
+func (e *UpstreamError) needsNewVQD() bool {
+	return e.class().NewVQD
+}
+
+func (e *StreamError) class() upstreamErrorClass {
+	return classifyUpstreamError(e.Type, e.Status)
+}
+
+func (e *StreamError) Retryable() bool {
+	return e.class().Transient
+}
+
+func (e *StreamError) needsNewVQD() bool {
+	return e.class().NewVQD
+}
+
+// Erreur upstream classée, qu'elle vienne du statut HTTP ou d'un événement du stream
+type classifiedError interface {
+	error
+	class() upstreamErrorClass
+	Retryable() bool
+	needsNewVQD() bool
+}
+
+// Classement d'une erreur upstream: statut HTTP et code renvoyés au client,
+// caractère passager (retry) et renouvellement du token VQD
+type upstreamErrorClass struct {
+	Status    int
+	Code      string
+	Transient bool
+	NewVQD    bool
+}
+
+func classifyUpstreamError(errType string, status int) upstreamErrorClass {
+	switch {
+	case errType == "ERR_INVALID_VQD":
+		return upstreamErrorClass{Status: http.StatusBadGateway, Code: "invalid_vqd", Transient: true, NewVQD: true}
+	case errType == "ERR_CHALLENGE" || status == http.StatusTeapot:
+		return upstreamErrorClass{Status: http.StatusServiceUnavailable, Code: "upstream_challenge", Transient: true, NewVQD: true}
+	case errType == "ERR_RATE_LIMIT" || errType == "ERR_TOO_MANY_REQUESTS" || status == http.StatusTooManyRequests:
+		return upstreamErrorClass{Status: http.StatusTooManyRequests, Code: "rate_limit_exceeded", Transient: true, NewVQD: true}
+	case errType == "ERR_CONVERSATION_LIMIT" || errType == "ERR_INPUT_LIMIT":
+		// Conversation trop longue: seule une nouvelle session peut aboutir
+		return upstreamErrorClass{Status: http.StatusBadRequest, Code: "context_length_exceeded"}
+	case errType == "ERR_SERVICE_UNAVAILABLE" || status >= 500:
+		return upstreamErrorClass{Status: http.StatusServiceUnavailable, Code: "upstream_unavailable", Transient: true}
+	}
+	return upstreamErrorClass{Status: http.StatusBadGateway, Code: "upstream_error"}
+}
+
+// Statut HTTP et code à renvoyer au client pour une erreur de chat ou de stream
+// (500 pour les erreurs qui ne viennent pas de l'upstream)
+func errorStatus(err error) (int, string) {
+	var classified classifiedError
+	if errors.As(err, &classified) {
+		class := classified.class()
+		return class.Status, class.Code
+	}
+	return http.StatusInternalServerError, "server_error"
+}
+
+// Échec définitif d'un envoi après un ou plusieurs essais
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	if e.Attempts == 1 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("échec après %d tentatives: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
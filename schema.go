@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validation d'une valeur JSON décodée contre un sous-ensemble de JSON Schema:
+// type, enum, const, properties, required, additionalProperties, items,
+// min/maxItems, min/maxLength, pattern, minimum/maximum (et exclusifs),
+// anyOf, oneOf, allOf et $ref locaux (#/$defs/..., #/definitions/...)
+type schemaValidator struct {
+	root   map[string]interface{}
+	errors []string
+	depth  int
+}
+
+// Imbrication maximale des schémas évalués pour une valeur (garde-fou en plus de
+// checkJSONSchema)
+const maxSchemaDepth = 256
+
+// Vérification d'un schéma avant usage: références locales résolubles et aucun
+// cycle de $ref qui ne descende pas dans la valeur (ex: {"allOf": [{"$ref": "#"}]}),
+// qui ferait boucler la validation sans fin. Les schémas récursifs via properties,
+// items ou additionalProperties restent acceptés.
+func checkJSONSchema(schema map[string]interface{}) error {
+	v := &schemaValidator{root: schema}
+
+	// Références présentes dans tout le schéma et cibles de chacune
+	refs := map[string]map[string]interface{}{"#": schema}
+	var collect func(node interface{}) error
+	collect = func(node interface{}) error {
+		switch typed := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := typed["$ref"].(string); ok && refs[ref] == nil {
+				target, err := v.resolve(ref)
+				if err != nil {
+					return err
+				}
+				refs[ref] = target
+			}
+			for _, child := range typed {
+				if err := collect(child); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, child := range typed {
+				if err := collect(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := collect(schema); err != nil {
+		return err
+	}
+
+	// Références atteintes depuis un schéma sans descendre dans la valeur
+	edges := make(map[string][]string)
+	var sameValue func(node map[string]interface{}, from string)
+	sameValue = func(node map[string]interface{}, from string) {
+		if ref, ok := node["$ref"].(string); ok {
+			edges[from] = append(edges[from], ref)
+		}
+		for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+			list, _ := node[keyword].([]interface{})
+			for _, sub := range list {
+				if subSchema, ok := sub.(map[string]interface{}); ok {
+					sameValue(subSchema, from)
+				}
+			}
+		}
+	}
+	for ref, target := range refs {
+		sameValue(target, ref)
+	}
+
+	// Recherche de cycle (parcours en profondeur)
+	state := make(map[string]int) // 1 = en cours, 2 = terminé
+	var visit func(ref string) error
+	visit = func(ref string) error {
+		switch state[ref] {
+		case 1:
+			return fmt.Errorf("référence circulaire %q", ref)
+		case 2:
+			return nil
+		}
+		state[ref] = 1
+		for _, next := range edges[ref] {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[ref] = 2
+		return nil
+	}
+	for ref := range refs {
+		if err := visit(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validation complète: renvoie la liste des erreurs (vide si conforme)
+func validateJSONSchema(schema map[string]interface{}, value interface{}) []string {
+	v := &schemaValidator{root: schema}
+	v.validate(schema, value, "$")
+	return v.errors
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string) {
+	if v.depth >= maxSchemaDepth {
+		v.fail(path, "schéma trop imbriqué")
+		return
+	}
+	v.depth++
+	defer func() { v.depth-- }()
+
+	// Une référence peut mener à une autre référence
+	for hops := 0; ; hops++ {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			break
+		}
+		if hops >= maxSchemaDepth {
+			v.fail(path, "référence circulaire %q", ref)
+			return
+		}
+		resolved, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		schema = resolved
+	}
+
+	if types, ok := schemaTypes(schema["type"]); ok && !matchesAnyType(types, value) {
+		v.fail(path, "type %s attendu, %s reçu", strings.Join(types, " ou "), jsonTypeOf(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		v.fail(path, "valeur non autorisée, attendu une de %s", compactJSON(enum))
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		v.fail(path, "valeur %s attendue", compactJSON(constant))
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, typed, path)
+	case []interface{}:
+		v.validateArray(schema, typed, path)
+	case string:
+		v.validateString(schema, typed, path)
+	case float64:
+		v.validateNumber(schema, typed, path)
+	}
+
+	v.validateCombinators(schema, value, path)
+}
+
+func (v *schemaValidator) validateObject(schema map[string]interface{}, object map[string]interface{}, path string) {
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := object[key]; !exists {
+					v.fail(path, "propriété requise manquante %q", key)
+				}
+			}
+		}
+	}
+
+	// Ordre stable des messages d'erreur
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if sub, ok := properties[key].(map[string]interface{}); ok {
+			v.validate(sub, object[key], path+"."+key)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(path, "propriété non autorisée %q", key)
+			}
+		case map[string]interface{}:
+			v.validate(additional, object[key], path+"."+key)
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(schema map[string]interface{}, array []interface{}, path string) {
+	if min, ok := schemaNumber(schema["minItems"]); ok && float64(len(array)) < min {
+		v.fail(path, "au moins %v éléments attendus", min)
+	}
+	if max, ok := schemaNumber(schema["maxItems"]); ok && float64(len(array)) > max {
+		v.fail(path, "au plus %v éléments attendus", max)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range array {
+			v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(schema map[string]interface{}, text string, path string) {
+	length := float64(utf8.RuneCountInString(text))
+	if min, ok := schemaNumber(schema["minLength"]); ok && length < min {
+		v.fail(path, "au moins %v caractères attendus", min)
+	}
+	if max, ok := schemaNumber(schema["maxLength"]); ok && length > max {
+		v.fail(path, "au plus %v caractères attendus", max)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err == nil && !re.MatchString(text) {
+			v.fail(path, "ne correspond pas au motif %q", pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(schema map[string]interface{}, number float64, path string) {
+	if min, ok := schemaNumber(schema["minimum"]); ok && number < min {
+		v.fail(path, "valeur >= %v attendue", min)
+	}
+	if max, ok := schemaNumber(schema["maximum"]); ok && number > max {
+		v.fail(path, "valeur <= %v attendue", max)
+	}
+	if min, ok := schemaNumber(schema["exclusiveMinimum"]); ok && number <= min {
+		v.fail(path, "valeur > %v attendue", min)
+	}
+	if max, ok := schemaNumber(schema["exclusiveMaximum"]); ok && number >= max {
+		v.fail(path, "valeur < %v attendue", max)
+	}
+}
+
+func (v *schemaValidator) validateCombinators(schema map[string]interface{}, value interface{}, path string) {
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				v.validate(subSchema, value, path)
+			}
+		}
+	}
+
+	countMatches := func(list []interface{}) int {
+		matches := 0
+		for _, sub := range list {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				nested := &schemaValidator{root: v.root, depth: v.depth}
+				nested.validate(subSchema, value, path)
+				if len(nested.errors) == 0 {
+					matches++
+				}
+			}
+		}
+		return matches
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && countMatches(anyOf) == 0 {
+		v.fail(path, "ne correspond à aucun des schémas de anyOf")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok && countMatches(oneOf) != 1 {
+		v.fail(path, "doit correspondre à exactement un des schémas de oneOf")
+	}
+}
+
+// Résolution d'une référence locale (#, #/$defs/nom ou #/definitions/nom)
+func (v *schemaValidator) resolve(ref string) (map[string]interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("référence non supportée %q", ref)
+	}
+
+	var node interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("référence introuvable %q", ref)
+		}
+		node = object[strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")]
+	}
+
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("référence introuvable %q", ref)
+	}
+	return schema, nil
+}
+
+// Lecture du mot-clé "type" (chaîne ou liste)
+func schemaTypes(raw interface{}) ([]string, bool) {
+	switch typed := raw.(type) {
+	case string:
+		return []string{typed}, true
+	case []interface{}:
+		var types []string
+		for _, t := range typed {
+			if name, ok := t.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types, len(types) > 0
+	}
+	return nil, false
+}
+
+func matchesAnyType(types []string, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	for _, expected := range types {
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// Type JSON Schema d'une valeur décodée par encoding/json
+func jsonTypeOf(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if typed == math.Trunc(typed) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "inconnu"
+}
+
+func schemaNumber(raw interface{}) (float64, bool) {
+	number, ok := raw.(float64)
+	return number, ok
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, candidate := range list {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compactJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decodeSchema(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &schema); err != nil {
+		t.Fatalf("schéma de test invalide: %v", err)
+	}
+	return schema
+}
+
+func TestCheckJSONSchemaRejectsReferenceCycles(t *testing.T) {
+	cases := map[string]string{
+		"racine":       `{"allOf": [{"$ref": "#"}]}`,
+		"définitions":  `{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"anyOf": [{"$ref": "#/$defs/a"}]}}, "$ref": "#/$defs/a"}`,
+		"introuvable":  `{"properties": {"a": {"$ref": "#/$defs/absent"}}}`,
+		"non supporté": `{"properties": {"a": {"$ref": "https://example.com/schema"}}}`,
+	}
+	for name, raw := range cases {
+		if err := checkJSONSchema(decodeSchema(t, raw)); err == nil {
+			t.Errorf("%s: schéma accepté", name)
+		}
+	}
+}
+
+func TestCheckJSONSchemaAcceptsRecursiveSchemas(t *testing.T) {
+	schema := decodeSchema(t, `{
+		"$defs": {"node": {"type": "object", "properties": {
+			"children": {"type": "array", "items": {"$ref": "#/$defs/node"}},
+			"parent": {"$ref": "#"}
+		}}},
+		"$ref": "#/$defs/node"
+	}`)
+	if err := checkJSONSchema(schema); err != nil {
+		t.Fatalf("schéma récursif refusé: %v", err)
+	}
+
+	var value interface{}
+	json.Unmarshal([]byte(`{"children": [{"children": []}, {"children": "x"}]}`), &value)
+	errors := validateJSONSchema(schema, value)
+	if len(errors) != 1 || !strings.Contains(errors[0], "$.children[1].children") {
+		t.Fatalf("erreurs inattendues: %v", errors)
+	}
+}
+
+func TestResponseFormatRejectsCyclicSchema(t *testing.T) {
+	format := &ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaSpec{
+		Schema: decodeSchema(t, `{"properties": {"a": {"allOf": [{"$ref": "#/properties/a"}]}}}`),
+	}}
+	err := format.validate()
+	if err == nil || !strings.Contains(err.Error(), "schema invalide") {
+		t.Fatalf("erreur attendue, reçu %v", err)
+	}
+}
+
+func TestValidateFollowsChainedReferences(t *testing.T) {
+	schema := decodeSchema(t, `{
+		"$defs": {"id": {"$ref": "#/$defs/positive"}, "positive": {"type": "integer", "minimum": 1}},
+		"properties": {"id": {"$ref": "#/$defs/id"}}
+	}`)
+	var value interface{}
+	json.Unmarshal([]byte(`{"id": 0}`), &value)
+	if errors := validateJSONSchema(schema, value); len(errors) != 1 {
+		t.Fatalf("une erreur attendue, reçu %v", errors)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Réponse scriptée: résultats d'outils puis texte découpé en chunks, ou erreur à
+// l'envoi / en cours de stream
+type ScriptedReply struct {
+	Tools     []ToolOutput
+	Chunks    []string
+	SendErr   error
+	StreamErr error
+}
+
+// Fournisseur en mémoire pour les tests et le mode hors ligne: les réponses sont
+// consommées dans l'ordre, puis le message reçu est renvoyé en écho
+type ScriptedProvider struct {
+	mu      sync.Mutex
+	replies []ScriptedReply
+
+	// Échec de création de conversation (ex: VQD introuvable)
+	NewErr error
+
+	// Contenus reçus, dans l'ordre d'envoi, et outils de recherche de chaque envoi
+	Sent      []string
+	SentTools []ToolChoice
+}
+
+func NewScriptedProvider(replies ...ScriptedReply) *ScriptedProvider {
+	return &ScriptedProvider{replies: replies}
+}
+
+func (p *ScriptedProvider) Name() string {
+	return "scripted"
+}
+
+// Ajout de réponses au script
+func (p *ScriptedProvider) Enqueue(replies ...ScriptedReply) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replies = append(p.replies, replies...)
+}
+
+func (p *ScriptedProvider) NewConversation(model Model) (Conversation, error) {
+	if p.NewErr != nil {
+		return nil, p.NewErr
+	}
+	return &ScriptedConversation{provider: p, model: model}, nil
+}
+
+// Prochaine réponse du script (écho du contenu lorsque le script est épuisé)
+func (p *ScriptedProvider) next(content string, tools ToolChoice) ScriptedReply {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Sent = append(p.Sent, content)
+	p.SentTools = append(p.SentTools, tools)
+	if len(p.replies) == 0 {
+		return ScriptedReply{Chunks: []string{"echo: ", content}}
+	}
+	reply := p.replies[0]
+	p.replies = p.replies[1:]
+	return reply
+}
+
+// Conversation scriptée, avec le même historique et les mêmes limites qu'une ChatSession
+type ScriptedConversation struct {
+	provider *ScriptedProvider
+	model    Model
+	tools    ToolChoice
+	messages []Message
+	result   StreamResult
+	resultMu sync.Mutex // result est écrit par la goroutine du stream
+
+	// Outils de recherche du dernier envoi
+	SentTools ToolChoice
+}
+
+func (s *ScriptedConversation) CurrentModel() Model {
+	return s.model
+}
+
+func (s *ScriptedConversation) SetModel(model Model) {
+	s.model = model
+}
+
+func (s *ScriptedConversation) CurrentTools() ToolChoice {
+	return s.tools
+}
+
+func (s *ScriptedConversation) SetTools(tools ToolChoice) {
+	s.tools = tools
+}
+
+func (s *ScriptedConversation) LastResult() StreamResult {
+	s.resultMu.Lock()
+	defer s.resultMu.Unlock()
+	return s.result
+}
+
+func (s *ScriptedConversation) setResult(result StreamResult) {
+	s.resultMu.Lock()
+	defer s.resultMu.Unlock()
+	s.result = result
+}
+
+func (s *ScriptedConversation) Clear() {
+	s.messages = []Message{}
+}
+
+func (s *ScriptedConversation) Send(ctx context.Context, content string, opts StreamOptions) (chan UpstreamEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.SentTools = s.tools
+	if opts.Tools != nil {
+		s.SentTools = *opts.Tools
+	}
+	reply := s.provider.next(content, s.SentTools)
+	s.setResult(StreamResult{Attempts: 1})
+	if reply.SendErr != nil {
+		return nil, reply.SendErr
+	}
+	s.messages = append(s.messages, Message{Role: "user", Content: content})
+
+	// Tampon suffisant pour toute la réponse: l'écriture ne bloque jamais
+	events := make(chan UpstreamEvent, len(reply.Tools)+len(reply.Chunks)+3)
+
+	go func() {
+		defer close(events)
+
+		meta := &UpstreamMetadata{ID: generateID("scripted-"), Model: string(s.model), Role: "assistant", Created: time.Now().Unix()}
+		events <- UpstreamEvent{Kind: EventMetadata, Metadata: meta}
+		for i := range reply.Tools {
+			events <- UpstreamEvent{Kind: EventToolOutput, Tool: &reply.Tools[i]}
+		}
+
+		limiter := newStreamLimiter(opts)
+		var answer strings.Builder
+		stopped := false
+		for _, chunk := range reply.Chunks {
+			text, stop := limiter.Push(chunk)
+			if text != "" {
+				events <- UpstreamEvent{Kind: EventTextDelta, Text: text}
+				answer.WriteString(text)
+			}
+			if stop {
+				stopped = true
+				break
+			}
+		}
+
+		if !stopped {
+			if reply.StreamErr != nil {
+				events <- UpstreamEvent{Kind: EventError, Err: fmt.Errorf("erreur lecture stream: %v", reply.StreamErr)}
+				return
+			}
+			if text := limiter.Flush(); text != "" {
+				events <- UpstreamEvent{Kind: EventTextDelta, Text: text}
+				answer.WriteString(text)
+			}
+		}
+		result := limiter.Result()
+		result.Attempts = 1
+		result.Model = meta.Model
+		result.MessageID = meta.ID
+		s.setResult(result)
+
+		if answer.Len() > 0 {
+			s.messages = append(s.messages, Message{Role: "assistant", Content: answer.String()})
+		}
+		events <- UpstreamEvent{Kind: EventDone, Result: result}
+	}()
+
+	return events, nil
+}
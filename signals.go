@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/rand"
+	"time"
+)
+
+// Télémétrie envoyée dans x-fe-signals: ouverture de la page (start), événements
+// de l'interface datés depuis l'ouverture (delta) et temps écoulé à l'envoi (end),
+// en millisecondes.
+//
+// Seuls les événements relevés sur le client web sont émis: startNewChat à la
+// création du chat et initSwitchModel à chaque changement de modèle. Aucun événement
+// propre à l'envoi d'un message n'a été relevé (la capture de référence ne contient
+// que startNewChat); en inventer serait plus suspect que leur absence. D'un message
+// à l'autre, seule la valeur de end progresse, avec le temps réel de la conversation.
+// Tout nouvel événement relevé s'ajoute par un appel à record au point correspondant.
+type feSignals struct {
+	Start  time.Time
+	Events []feSignalEvent
+}
+
+type feSignalEvent struct {
+	Name  string `json:"name"`
+	Delta int64  `json:"delta"`
+}
+
+// Télémétrie d'un nouveau chat: la page est supposée ouverte quelques secondes
+// avant la création de la session, le chat démarré peu après
+func newFeSignals() *feSignals {
+	start := time.Now().Add(-time.Duration(1500+rand.Intn(1500)) * time.Millisecond)
+	return &feSignals{
+		Start:  start,
+		Events: []feSignalEvent{{Name: "startNewChat", Delta: int64(40 + rand.Intn(80))}},
+	}
+}
+
+// Enregistrement d'un événement de l'interface à l'instant présent
+func (s *feSignals) record(name string) {
+	s.Events = append(s.Events, feSignalEvent{Name: name, Delta: time.Since(s.Start).Milliseconds()})
+}
+
+// Valeur du header pour une requête envoyée maintenant
+func (s *feSignals) encode() string {
+	payload := struct {
+		Start  int64           `json:"start"`
+		Events []feSignalEvent `json:"events"`
+		End    int64           `json:"end"`
+	}{
+		Start:  s.Start.UnixMilli(),
+		Events: s.Events,
+		End:    time.Since(s.Start).Milliseconds(),
+	}
+	data, _ := json.Marshal(payload)
+	return base64.StdEncoding.EncodeToString(data)
+}
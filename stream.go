@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Nombre moyen de caractères par token, utilisé pour estimer et limiter les réponses
+const charsPerToken = 4
+
+// Limites appliquées côté serveur à la lecture du stream upstream,
+// DuckDuckGo n'offrant ni stop ni max_tokens, et outils de recherche de l'envoi
+type StreamOptions struct {
+	Stop      []string
+	MaxTokens int
+
+	// Outils de recherche pour cet envoi (nil = ceux de la session)
+	Tools *ToolChoice
+}
+
+// Issue d'une réponse: raison de fin ("stop" ou "length"), séquence d'arrêt rencontrée,
+// nombre de requêtes upstream (retries compris) qu'il a fallu pour l'obtenir, et
+// identité (modèle, ID de message) annoncée par l'upstream
+type StreamResult struct {
+	FinishReason string
+	StopSequence string
+	Attempts     int
+	Model        string
+	MessageID    string
+}
+
+// Séquences d'arrêt OpenAI: chaîne simple ou liste de chaînes
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var stop string
+	if err := json.Unmarshal(data, &stop); err == nil {
+		*s = StopSequences{stop}
+		return nil
+	}
+
+	var stops []string
+	if err := json.Unmarshal(data, &stops); err != nil {
+		return fmt.Errorf("stop invalide: chaîne ou liste de chaînes attendue")
+	}
+	*s = StopSequences(stops)
+	return nil
+}
+
+// Coupure du texte reçu à la première séquence d'arrêt ou au budget de tokens.
+// La fin du texte pouvant être le début d'une séquence d'arrêt est retenue
+// jusqu'au chunk suivant; seul ce texte retenu et le nouveau chunk sont parcourus.
+type streamLimiter struct {
+	stop     []string
+	maxLen   int // longueur de la plus longue séquence d'arrêt
+	maxRunes int // 0 = illimité
+	pending  string
+	runes    int // caractères émis ou retenus
+
+	FinishReason string
+	StopSequence string
+}
+
+func newStreamLimiter(opts StreamOptions) *streamLimiter {
+	l := &streamLimiter{maxRunes: opts.MaxTokens * charsPerToken}
+	for _, stop := range opts.Stop {
+		if stop == "" {
+			continue
+		}
+		l.stop = append(l.stop, stop)
+		if len(stop) > l.maxLen {
+			l.maxLen = len(stop)
+		}
+	}
+	return l
+}
+
+// Ajout d'un chunk: renvoie le texte pouvant être émis et true si le stream doit s'arrêter
+func (l *streamLimiter) Push(chunk string) (string, bool) {
+	if chunk == "" {
+		return "", false
+	}
+	l.pending += chunk
+	l.runes += utf8.RuneCountInString(chunk)
+
+	// Première séquence d'arrêt rencontrée dans le texte non émis
+	cut := -1
+	for _, stop := range l.stop {
+		if i := strings.Index(l.pending, stop); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+			l.StopSequence = stop
+		}
+	}
+	if cut >= 0 {
+		l.runes -= utf8.RuneCountInString(l.pending[cut:])
+		l.pending = l.pending[:cut]
+		l.FinishReason = "stop"
+	}
+
+	// Budget de tokens dépassé: la réponse est coupée. L'atteindre exactement ne
+	// coupe rien, le stream peut encore se terminer de lui-même.
+	if l.maxRunes > 0 && l.runes > l.maxRunes {
+		keep := utf8.RuneCountInString(l.pending) - (l.runes - l.maxRunes)
+		l.pending = truncateRunes(l.pending, keep)
+		l.runes = l.maxRunes
+		l.FinishReason = "length"
+		l.StopSequence = ""
+		return l.Flush(), true
+	}
+	if cut >= 0 {
+		return l.Flush(), true
+	}
+
+	// Retenue d'un éventuel début de séquence d'arrêt, sans couper un caractère
+	safe := len(l.pending)
+	if l.maxLen > 1 {
+		safe -= l.maxLen - 1
+		for safe > 0 && !utf8.RuneStart(l.pending[safe]) {
+			safe--
+		}
+	}
+	if safe <= 0 {
+		return "", false
+	}
+
+	out := l.pending[:safe]
+	l.pending = l.pending[safe:]
+	return out, false
+}
+
+func (l *streamLimiter) Result() StreamResult {
+	return StreamResult{FinishReason: l.FinishReason, StopSequence: l.StopSequence}
+}
+
+// Émission du texte retenu (fin de stream)
+func (l *streamLimiter) Flush() string {
+	out := l.pending
+	l.pending = ""
+	if l.FinishReason == "" {
+		l.FinishReason = "stop"
+	}
+	return out
+}
+
+// Troncature d'un texte à n caractères
+func truncateRunes(text string, n int) string {
+	count := 0
+	for i := range text {
+		if count == n {
+			return text[:i]
+		}
+		count++
+	}
+	return text
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Passage de chunks dans un limiteur: texte émis, raison de fin et séquence d'arrêt
+func runLimiter(opts StreamOptions, chunks ...string) (string, StreamResult) {
+	limiter := newStreamLimiter(opts)
+	var out strings.Builder
+	for _, chunk := range chunks {
+		text, stop := limiter.Push(chunk)
+		out.WriteString(text)
+		if stop {
+			return out.String(), limiter.Result()
+		}
+	}
+	out.WriteString(limiter.Flush())
+	return out.String(), limiter.Result()
+}
+
+func TestStreamLimiterStopSequenceAcrossChunks(t *testing.T) {
+	text, result := runLimiter(StreamOptions{Stop: []string{"END"}}, "Bonjour E", "N", "D et la suite")
+	if text != "Bonjour " || result.FinishReason != "stop" || result.StopSequence != "END" {
+		t.Fatalf("texte %q, résultat %+v", text, result)
+	}
+}
+
+func TestStreamLimiterMaxTokens(t *testing.T) {
+	// 2 tokens = 8 caractères
+	text, result := runLimiter(StreamOptions{MaxTokens: 2}, "abcde", "fghij")
+	if text != "abcdefgh" || result.FinishReason != "length" {
+		t.Fatalf("texte %q, résultat %+v", text, result)
+	}
+
+	// Réponse qui s'arrête exactement au budget: rien n'est coupé
+	text, result = runLimiter(StreamOptions{MaxTokens: 2}, "abcd", "éfgh")
+	if text != "abcdéfgh" || result.FinishReason != "stop" {
+		t.Fatalf("texte %q, résultat %+v", text, result)
+	}
+}
+
+func TestStreamLimiterStopBeyondBudgetIsLength(t *testing.T) {
+	text, result := runLimiter(StreamOptions{MaxTokens: 1, Stop: []string{"!"}}, "abcdef!")
+	if text != "abcd" || result.FinishReason != "length" || result.StopSequence != "" {
+		t.Fatalf("texte %q, résultat %+v", text, result)
+	}
+}
+
+func TestStreamLimiterHoldsOnlyStopPrefix(t *testing.T) {
+	limiter := newStreamLimiter(StreamOptions{Stop: []string{"STOP"}})
+	text, _ := limiter.Push(strings.Repeat("x", 1000))
+	if len(text) != 997 || len(limiter.pending) != 3 {
+		t.Fatalf("%d caractères émis, %d retenus", len(text), len(limiter.pending))
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Nombre maximal d'essais (question initiale + relances) pour obtenir un JSON conforme
+const maxStructuredAttempts = 3
+
+// Format de réponse OpenAI (response_format)
+type ResponseFormat struct {
+	Type       string          `json:"type"`
+	JSONSchema *JSONSchemaSpec `json:"json_schema,omitempty"`
+}
+
+type JSONSchemaSpec struct {
+	Name   string                 `json:"name,omitempty"`
+	Schema map[string]interface{} `json:"schema,omitempty"`
+	Strict bool                   `json:"strict,omitempty"`
+}
+
+// Erreur renvoyée lorsque le modèle ne produit jamais de JSON conforme
+type StructuredOutputError struct {
+	Attempts int
+	Errors   []string
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("réponse non conforme au format demandé après %d essais: %s",
+		e.Attempts, strings.Join(e.Errors, "; "))
+}
+
+// Indique si le format impose une réponse JSON
+func (f *ResponseFormat) wantsJSON() bool {
+	return f != nil && (f.Type == "json_object" || f.Type == "json_schema")
+}
+
+// Vérification du format demandé
+func (f *ResponseFormat) validate() error {
+	switch {
+	case f == nil || f.Type == "" || f.Type == "text" || f.Type == "json_object":
+		return nil
+	case f.Type == "json_schema":
+		if f.JSONSchema == nil || f.JSONSchema.Schema == nil {
+			return fmt.Errorf("response_format.json_schema.schema requis")
+		}
+		if err := checkJSONSchema(f.JSONSchema.Schema); err != nil {
+			return fmt.Errorf("schema invalide: %v", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("response_format non supporté: %s", f.Type)
+	}
+}
+
+// Consigne ajoutée à la conversation pour obtenir du JSON
+func (f *ResponseFormat) instruction() string {
+	if f.Type == "json_schema" {
+		schema, _ := json.MarshalIndent(f.JSONSchema.Schema, "", "  ")
+		return "Reply only with a JSON value that conforms to the following JSON Schema, without any text or code fence around it.\n" + string(schema)
+	}
+	return "Reply only with a valid JSON object, without any text or code fence around it."
+}
+
+// Extraction et validation du JSON d'une réponse; renvoie le JSON nettoyé
+func (f *ResponseFormat) check(answer string) (string, []string) {
+	text := extractJSON(answer)
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return text, []string{fmt.Sprintf("JSON invalide: %v", err)}
+	}
+
+	if f.Type == "json_object" {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return text, []string{"$: un objet JSON est attendu"}
+		}
+		return text, nil
+	}
+	return text, validateJSONSchema(f.JSONSchema.Schema, value)
+}
+
+// Retrait des blocs de code et du texte entourant le JSON
+func extractJSON(answer string) string {
+	text := strings.TrimSpace(answer)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimPrefix(text, "json")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+		text = strings.TrimSpace(text)
+	}
+	if strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") {
+		return text
+	}
+
+	// Texte libre autour de l'objet: on garde le premier objet complet
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start >= 0 && end > start {
+		return text[start : end+1]
+	}
+	return text
+}
+
+// Échange structuré: la réponse est validée puis, si elle n'est pas conforme,
+// le modèle est relancé dans la même session avec les erreurs constatées
+func structuredExchange(ctx context.Context, session Conversation, content string, format *ResponseFormat, opts StreamOptions) (string, error) {
+	message := content
+	var problems []string
+
+	for attempt := 1; attempt <= maxStructuredAttempts; attempt++ {
+		answer, err := exchange(ctx, session, message, opts, func(string) {})
+		if err != nil {
+			return "", err
+		}
+
+		var text string
+		text, problems = format.check(answer)
+		if len(problems) == 0 {
+			return text, nil
+		}
+
+		log.Printf("🔁 Réponse JSON non conforme (essai %d/%d): %s", attempt, maxStructuredAttempts, strings.Join(problems, "; "))
+		message = "Your previous answer is not valid:\n- " + strings.Join(problems, "\n- ") +
+			"\n" + format.instruction()
+	}
+
+	return "", &StructuredOutputError{Attempts: maxStructuredAttempts, Errors: problems}
+}
+
+// Variante structurée de fanOutChat: les réponses sont validées avant d'être publiées,
+// chaque choice arrive donc en un seul morceau
+func fanOutStructured(ctx context.Context, sessions []Conversation, content string, format *ResponseFormat, opts StreamOptions) (chan choiceEvent, error) {
+	answers := make([]string, len(sessions))
+	errs := make([]error, len(sessions))
+
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session Conversation) {
+			defer wg.Done()
+			answers[i], errs[i] = structuredExchange(ctx, session, content, format, opts)
+		}(i, session)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	events := make(chan choiceEvent, 2*len(sessions))
+	for i, session := range sessions {
+		events <- choiceEvent{Index: i, Text: answers[i]}
+		events <- choiceEvent{Index: i, Done: true, FinishReason: session.LastResult().FinishReason}
+	}
+	close(events)
+	return events, nil
+}
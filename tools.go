@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Noms des outils de recherche DuckDuckGo acceptés dans search_tools
+var searchToolNames = []string{"news", "videos", "local", "weather"}
+
+// Noms upstream des outils, rendus sous leur nom de search_tools
+var upstreamToolNames = map[string]string{
+	"NewsSearch":      "news",
+	"VideosSearch":    "videos",
+	"LocalSearch":     "local",
+	"WeatherForecast": "weather",
+}
+
+// Champs lus dans chaque résultat upstream, par ordre de préférence
+var (
+	toolListFields    = []string{"results", "data", "items"}
+	toolTitleFields   = []string{"title", "headline", "location"}
+	toolURLFields     = []string{"url", "link", "href"}
+	toolSnippetFields = []string{"snippet", "excerpt", "description", "body", "summary"}
+)
+
+// Conversion d'un événement d'outil upstream en ToolOutput: la liste de résultats
+// (champ results, data ou items, ou l'événement lui-même) réduite à titre, URL et
+// extrait. Les résultats sans titre ni URL sont ignorés.
+func normalizeToolOutput(name string, data []byte) *ToolOutput {
+	output := &ToolOutput{Name: name, Results: []ToolResult{}}
+	if short, known := upstreamToolNames[name]; known {
+		output.Name = short
+	}
+
+	var event map[string]json.RawMessage
+	json.Unmarshal(data, &event)
+	var items []map[string]interface{}
+	for _, field := range toolListFields {
+		if json.Unmarshal(event[field], &items) == nil && len(items) > 0 {
+			break
+		}
+	}
+	if len(items) == 0 {
+		var single map[string]interface{}
+		json.Unmarshal(data, &single)
+		items = append(items, single)
+	}
+
+	for _, item := range items {
+		result := ToolResult{
+			Title:   firstString(item, toolTitleFields),
+			URL:     firstString(item, toolURLFields),
+			Snippet: firstString(item, toolSnippetFields),
+		}
+		if result.Title != "" || result.URL != "" {
+			output.Results = append(output.Results, result)
+		}
+	}
+	return output
+}
+
+// Première valeur texte non vide parmi fields
+func firstString(item map[string]interface{}, fields []string) string {
+	for _, field := range fields {
+		if value, ok := item[field].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Conversion d'une liste d'outils ("news", "videos", "local", "weather") en choix
+// d'outils upstream; nil si la liste est absente, tous désactivés si elle est vide
+func parseSearchTools(names []string) (*ToolChoice, error) {
+	if names == nil {
+		return nil, nil
+	}
+
+	tools := &ToolChoice{}
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "news":
+			tools.NewsSearch = true
+		case "videos":
+			tools.VideosSearch = true
+		case "local":
+			tools.LocalSearch = true
+		case "weather":
+			tools.WeatherForecast = true
+		default:
+			return nil, fmt.Errorf("outil de recherche inconnu: %s (disponibles: %s)", name, strings.Join(searchToolNames, ", "))
+		}
+	}
+	return tools, nil
+}
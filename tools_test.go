@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchTools(t *testing.T) {
+	tools, err := parseSearchTools([]string{"News", " weather "})
+	if err != nil || !tools.NewsSearch || !tools.WeatherForecast || tools.LocalSearch {
+		t.Fatalf("outils %+v, erreur %v", tools, err)
+	}
+	if tools, _ := parseSearchTools(nil); tools != nil {
+		t.Fatal("liste absente: nil attendu")
+	}
+	if _, err := parseSearchTools([]string{"images"}); err == nil {
+		t.Fatal("outil inconnu accepté")
+	}
+}
+
+func TestNormalizeToolOutput(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		want ToolOutput
+	}{
+		{
+			name: "NewsSearch",
+			data: `{"role": "tool", "name": "NewsSearch", "results": [{"title": "Titre", "url": "https://a.example", "excerpt": "Extrait", "image": "x.png"}, {"image": "y.png"}]}`,
+			want: ToolOutput{Name: "news", Results: []ToolResult{{Title: "Titre", URL: "https://a.example", Snippet: "Extrait"}}},
+		},
+		{
+			name: "VideosSearch",
+			data: `{"role": "tool", "name": "VideosSearch", "data": [{"headline": "Vidéo", "link": "https://v.example"}]}`,
+			want: ToolOutput{Name: "videos", Results: []ToolResult{{Title: "Vidéo", URL: "https://v.example"}}},
+		},
+		{
+			name: "WeatherForecast",
+			data: `{"role": "tool", "name": "WeatherForecast", "location": "Paris", "summary": "Ensoleillé"}`,
+			want: ToolOutput{Name: "weather", Results: []ToolResult{{Title: "Paris", Snippet: "Ensoleillé"}}},
+		},
+		{
+			name: "FutureTool",
+			data: `{"role": "tool", "name": "FutureTool"}`,
+			want: ToolOutput{Name: "FutureTool", Results: []ToolResult{}},
+		},
+	}
+	for _, tc := range cases {
+		got := normalizeToolOutput(tc.name, []byte(tc.data))
+		if !reflect.DeepEqual(*got, tc.want) {
+			t.Errorf("%s: %+v, attendu %+v", tc.name, *got, tc.want)
+		}
+	}
+}
+
+// Un événement de résultats d'outil de plus de 64 Kio ne coupe pas la réponse
+func TestStreamAcceptsLargeToolResults(t *testing.T) {
+	var results []map[string]string
+	for i := 0; i < 2000; i++ {
+		results = append(results, map[string]string{
+			"title":   fmt.Sprintf("Article %d", i),
+			"url":     fmt.Sprintf("https://example.com/%d", i),
+			"excerpt": strings.Repeat("texte ", 20),
+		})
+	}
+	tool, _ := json.Marshal(map[string]interface{}{"role": "tool", "name": "NewsSearch", "action": "success", "results": results})
+	if len(tool) < 256*1024 {
+		t.Fatalf("événement de %d octets seulement", len(tool))
+	}
+	body := fmt.Sprintf("data: %s\n\ndata: {\"role\":\"assistant\",\"message\":\"Voici\",\"action\":\"success\"}\n\ndata: [DONE]\n\n", tool)
+
+	session := &ChatSession{}
+	events := session.ProcessStreamResponse(context.Background(), &http.Response{Body: io.NopCloser(strings.NewReader(body))}, StreamOptions{})
+	var tools []*ToolOutput
+	var text strings.Builder
+	if err := drainEvents(events, func(event UpstreamEvent) {
+		switch event.Kind {
+		case EventToolOutput:
+			tools = append(tools, event.Tool)
+		case EventTextDelta:
+			text.WriteString(event.Text)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tools) != 1 || len(tools[0].Results) != 2000 || text.String() != "Voici" {
+		t.Errorf("%d résultats d'outil, texte %q", len(tools), text.String())
+	}
+}
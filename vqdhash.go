@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Le endpoint de statut renvoie dans x-vqd-hash-1 un script JS (en base64) que le
+// navigateur exécute pour produire le header x-vqd-hash-1 des requêtes de chat:
+//
+//	{server_hashes: [...], client_hashes: [navigator.userAgent, String(N + (...innerHTML = '<html>'...).length)],
+//	 signals: {}, meta: {v: '4', challenge_id: '...', timestamp: '...'}}
+//
+// Le solveur extrait ces valeurs sans moteur JS: les hashes serveur sont recopiés,
+// chaque entrée client est évaluée puis hachée en SHA-256 (base64).
+
+var (
+	challengeServerHashes = regexp.MustCompile(`server_hashes['"]?\s*:\s*\[([^\]]*)\]`)
+	challengeClientHashes = regexp.MustCompile(`(?s)client_hashes['"]?\s*:\s*\[(.*?)\]\s*,\s*['"]?signals`)
+	challengeQuoted       = regexp.MustCompile(`'([^']*)'|"([^"]*)"`)
+	challengeInnerHTML    = regexp.MustCompile(`innerHTML\s*=\s*(?:'([^']*)'|"([^"]*)")`)
+	challengeOffset       = regexp.MustCompile(`String\(\s*(0x[0-9a-fA-F]+|\d+)\s*\+`)
+)
+
+// Pile d'appel reproduite dans meta.stack (celle du bundle chat du site)
+const challengeStack = "Error\nat ba (https://duckduckgo.com/dist/wpm.chat.cafd73f97f51c983eb30.js:1:74803)\nat async dispatchServiceInitialVQD (https://duckduckgo.com/dist/wpm.chat.cafd73f97f51c983eb30.js:1:99529)"
+
+// Challenge que le solveur ne sait pas traiter (format inconnu, entrée client non
+// supportée): le header x-vqd-hash-1 ne peut pas être calculé
+type ChallengeError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ChallengeError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("challenge x-vqd-hash-1 non résolu: %s: %v", e.Reason, e.Err)
+	}
+	return "challenge x-vqd-hash-1 non résolu: " + e.Reason
+}
+
+func (e *ChallengeError) Unwrap() error {
+	return e.Err
+}
+
+// Valeur d'un champ meta du script (challenge_id, timestamp, v)
+func challengeMeta(script, field string) string {
+	re := regexp.MustCompile(field + `['"]?\s*:\s*(?:'([^']*)'|"([^"]*)")`)
+	match := re.FindStringSubmatch(script)
+	if match == nil {
+		return ""
+	}
+	return match[1] + match[2]
+}
+
+// Calcul du header x-vqd-hash-1 à partir du challenge délivré par le endpoint de statut
+func solveVQDChallenge(challenge, userAgent string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(challenge)
+	if err != nil {
+		return "", &ChallengeError{Reason: "challenge non décodable", Err: err}
+	}
+	script := string(decoded)
+
+	server := challengeServerHashes.FindStringSubmatch(script)
+	client := challengeClientHashes.FindStringSubmatch(script)
+	challengeID := challengeMeta(script, "challenge_id")
+	if server == nil || client == nil || challengeID == "" {
+		return "", &ChallengeError{Reason: "challenge non reconnu"}
+	}
+
+	serverHashes := []string{}
+	for _, match := range challengeQuoted.FindAllStringSubmatch(server[1], -1) {
+		serverHashes = append(serverHashes, match[1]+match[2])
+	}
+
+	clientHashes := []string{}
+	for _, expr := range splitTopLevel(client[1]) {
+		value, err := evalChallengeInput(expr, userAgent)
+		if err != nil {
+			return "", &ChallengeError{Reason: err.Error()}
+		}
+		sum := sha256.Sum256([]byte(value))
+		clientHashes = append(clientHashes, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	version := challengeMeta(script, "v")
+	if version == "" {
+		version = "4"
+	}
+	answer := map[string]interface{}{
+		"server_hashes": serverHashes,
+		"client_hashes": clientHashes,
+		"signals":       map[string]interface{}{},
+		"meta": map[string]interface{}{
+			"v":            version,
+			"challenge_id": challengeID,
+			"timestamp":    challengeMeta(script, "timestamp"),
+			"origin":       "https://duckduckgo.com",
+			"stack":        challengeStack,
+			"duration":     strconv.Itoa(5 + rand.Intn(30)),
+		},
+	}
+	data, _ := json.Marshal(answer)
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Évaluation d'une entrée client du script: user agent, longueur d'un fragment
+// HTML (avec décalage éventuel) ou littéral
+func evalChallengeInput(expr, userAgent string) (string, error) {
+	expr = strings.TrimSpace(expr)
+	switch {
+	case strings.Contains(expr, "navigator.userAgent"):
+		return userAgent, nil
+	case strings.Contains(expr, "innerHTML"):
+		fragment := challengeInnerHTML.FindStringSubmatch(expr)
+		if fragment == nil {
+			return "", fmt.Errorf("fragment HTML du challenge non reconnu")
+		}
+		length, err := innerHTMLLength(fragment[1] + fragment[2])
+		if err != nil {
+			return "", err
+		}
+		value := int64(length)
+		if offset := challengeOffset.FindStringSubmatch(expr); offset != nil {
+			n, err := strconv.ParseInt(offset[1], 0, 64)
+			if err != nil {
+				return "", fmt.Errorf("décalage du challenge invalide: %s", offset[1])
+			}
+			value += n
+		}
+		return strconv.FormatInt(value, 10), nil
+	}
+	if match := challengeQuoted.FindStringSubmatch(expr); match != nil && len(match[0]) == len(expr) {
+		return match[1] + match[2], nil
+	}
+	return "", fmt.Errorf("entrée du challenge non supportée: %.40s", expr)
+}
+
+// Longueur de innerHTML après affectation du fragment: le navigateur l'analyse puis
+// le resérialise (balises fermées, éléments vides sans "/", noms en minuscules,
+// entités décodées puis réencodées), et la longueur JS se compte en unités UTF-16.
+// html.Render ne suit pas cette sérialisation (<br/>, guillemets échappés dans le
+// texte): elle est reproduite par serializeHTML.
+func innerHTMLLength(fragment string) (int, error) {
+	if strings.Contains(fragment, `\`) {
+		return 0, fmt.Errorf("échappement JS dans le fragment HTML du challenge")
+	}
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return 0, fmt.Errorf("fragment HTML du challenge invalide: %v", err)
+	}
+	var b strings.Builder
+	for _, node := range nodes {
+		if err := serializeHTML(&b, node); err != nil {
+			return 0, err
+		}
+	}
+	return len(utf16.Encode([]rune(b.String()))), nil
+}
+
+// Éléments sans contenu ni balise fermante à la sérialisation
+var voidElements = map[string]bool{
+	"area": true, "base": true, "basefont": true, "bgsound": true, "br": true, "col": true,
+	"embed": true, "frame": true, "hr": true, "img": true, "input": true, "keygen": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Éléments dont le texte est sérialisé sans échappement
+var rawTextElements = map[string]bool{
+	"style": true, "script": true, "xmp": true, "iframe": true, "noembed": true,
+	"noframes": true, "plaintext": true, "noscript": true,
+}
+
+var (
+	htmlTextEscaper      = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", "<", "&lt;", ">", "&gt;")
+	htmlAttributeEscaper = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", `"`, "&quot;")
+)
+
+// Sérialisation HTML d'un nœud, selon l'algorithme de innerHTML. Les éléments SVG et
+// MathML (casse des noms adaptée par le navigateur) ne sont pas pris en charge.
+func serializeHTML(b *strings.Builder, node *html.Node) error {
+	switch node.Type {
+	case html.TextNode:
+		if node.Parent != nil && rawTextElements[node.Parent.Data] {
+			b.WriteString(node.Data)
+		} else {
+			b.WriteString(htmlTextEscaper.Replace(node.Data))
+		}
+	case html.CommentNode:
+		b.WriteString("<!--" + node.Data + "-->")
+	case html.ElementNode:
+		if node.Namespace != "" {
+			return fmt.Errorf("élément %s:%s non supporté dans le fragment HTML du challenge", node.Namespace, node.Data)
+		}
+		b.WriteString("<" + node.Data)
+		for _, attr := range node.Attr {
+			b.WriteString(" " + attr.Key + `="` + htmlAttributeEscaper.Replace(attr.Val) + `"`)
+		}
+		b.WriteString(">")
+		if voidElements[node.Data] {
+			return nil
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if err := serializeHTML(b, child); err != nil {
+				return err
+			}
+		}
+		b.WriteString("</" + node.Data + ">")
+	default:
+		return fmt.Errorf("nœud HTML non supporté dans le fragment du challenge")
+	}
+	return nil
+}
+
+// Découpage d'une liste JS sur les virgules de premier niveau
+func splitTopLevel(list string) []string {
+	var parts []string
+	depth := 0
+	var quote rune
+	start := 0
+	for i, r := range list {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(' || r == '[' || r == '{':
+			depth++
+		case r == ')' || r == ']' || r == '}':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, list[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(list[start:]) != "" {
+		parts = append(parts, list[start:])
+	}
+	return parts
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const challengeUserAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:139.0) Gecko/20100101 Firefox/139.0"
+
+// Challenge de testdata/challenges (script base64 tel que renvoyé dans x-vqd-hash-1)
+func readChallenge(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "challenges", name+".b64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func sha256Base64(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestSolveVQDChallengeFixtures(t *testing.T) {
+	cases := []struct {
+		fixture     string
+		inputs      []string // valeurs attendues des entrées client, avant hachage
+		challengeID string
+		timestamp   string
+	}{
+		{"site-v4", []string{challengeUserAgent, "12109"}, "857609b9f18658e1c436aeb60c4075f7aacfbb499acf855f42d5dcfc915b48b8h8jbt", "1749828576497"},
+		{"double-quoted", []string{challengeUserAgent, "4233", "fr-FR"}, "c0f3e8a1d2b4", "1752051234567"},
+		// <br><p class="x">a &amp; b</p><p>x</p> une fois resérialisé: 38 au lieu de 29
+		{"normalized-fragment", []string{challengeUserAgent, "48"}, "5e2d9c41a7f3", "1752051299001"},
+	}
+	for _, tc := range cases {
+		challenge := readChallenge(t, tc.fixture)
+		hash, err := solveVQDChallenge(challenge, challengeUserAgent)
+		if err != nil {
+			t.Errorf("%s: %v", tc.fixture, err)
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(hash)
+		if err != nil {
+			t.Fatalf("%s: réponse non base64: %v", tc.fixture, err)
+		}
+		var answer struct {
+			ServerHashes []string          `json:"server_hashes"`
+			ClientHashes []string          `json:"client_hashes"`
+			Meta         map[string]string `json:"meta"`
+		}
+		if err := json.Unmarshal(data, &answer); err != nil {
+			t.Fatalf("%s: réponse non JSON: %v", tc.fixture, err)
+		}
+
+		script, _ := base64.StdEncoding.DecodeString(challenge)
+		for _, server := range answer.ServerHashes {
+			if !strings.Contains(string(script), server) {
+				t.Errorf("%s: hash serveur %q absent du challenge", tc.fixture, server)
+			}
+		}
+		if len(answer.ServerHashes) != 2 {
+			t.Errorf("%s: %d hashes serveur", tc.fixture, len(answer.ServerHashes))
+		}
+		if len(answer.ClientHashes) != len(tc.inputs) {
+			t.Fatalf("%s: %d hashes client", tc.fixture, len(answer.ClientHashes))
+		}
+		for i, input := range tc.inputs {
+			if answer.ClientHashes[i] != sha256Base64(input) {
+				t.Errorf("%s: hash client %d ne correspond pas à %q", tc.fixture, i, input)
+			}
+		}
+		if answer.Meta["challenge_id"] != tc.challengeID || answer.Meta["timestamp"] != tc.timestamp || answer.Meta["v"] != "4" {
+			t.Errorf("%s: meta %v", tc.fixture, answer.Meta)
+		}
+	}
+}
+
+func TestSolveVQDChallengeErrors(t *testing.T) {
+	challenges := map[string]string{
+		"unsupported-input": readChallenge(t, "unsupported-input"),
+		"missing-id":        readChallenge(t, "missing-id"),
+		"non base64":        "pas du base64 !",
+		"script inconnu":    base64.StdEncoding.EncodeToString([]byte("(function(){return 42})()")),
+	}
+	for name, challenge := range challenges {
+		hash, err := solveVQDChallenge(challenge, challengeUserAgent)
+		var challengeErr *ChallengeError
+		if !errors.As(err, &challengeErr) || hash != "" {
+			t.Errorf("%s: hash %q, erreur %v", name, hash, err)
+		}
+	}
+}
+
+// Longueurs de innerHTML selon la sérialisation HTML des navigateurs
+func TestInnerHTMLLength(t *testing.T) {
+	cases := map[string]int{
+		"<li><div></div></li>":     20,
+		"<br/><p>x":                12, // <br><p>x</p>
+		"<DIV ID=a>b</DIV>":        19, // <div id="a">b</div>
+		"<img src='x.png'/>":       17, // <img src="x.png">
+		"<p>&eacute;t&eacute;</p>": 10, // <p>été</p>
+		"<p>a&nbsp;b</p>":          15,
+		"<td>x</td>":               1,  // cellule hors tableau: seul le texte reste
+		"<p>😀</p>":                 9,  // deux unités UTF-16
+		"<p title='a\"b'>c</p>":    25, // <p title="a&quot;b">c</p>
+	}
+	for fragment, want := range cases {
+		got, err := innerHTMLLength(fragment)
+		if err != nil || got != want {
+			t.Errorf("%s: %d (%v), attendu %d", fragment, got, err, want)
+		}
+	}
+
+	for _, fragment := range []string{"<svg><circle/></svg>", `<p>a\'b</p>`} {
+		if _, err := innerHTMLLength(fragment); err == nil {
+			t.Errorf("%s: accepté", fragment)
+		}
+	}
+}
+
+// Un challenge non résolu n'envoie pas d'ancienne réponse: le header est omis
+func TestSolveChallengeDropsStaleHash(t *testing.T) {
+	session := &ChatSession{Identity: &Identity{Fingerprint: &FingerprintProfile{UserAgent: challengeUserAgent}}}
+
+	session.solveChallenge(readChallenge(t, "site-v4"))
+	if session.VqdHash1 == "" {
+		t.Fatal("challenge du site non résolu")
+	}
+	session.solveChallenge(readChallenge(t, "unsupported-input"))
+	if session.VqdHash1 != "" {
+		t.Errorf("réponse précédente conservée: %s", session.VqdHash1)
+	}
+	session.solveChallenge(readChallenge(t, "site-v4"))
+	session.solveChallenge("")
+	if session.VqdHash1 != "" {
+		t.Errorf("réponse conservée sans challenge: %s", session.VqdHash1)
+	}
+}
+
+// Les challenges de l'émulateur sont résolus et acceptés par sa propre vérification
+func TestSolveEmulatorChallenge(t *testing.T) {
+	emulator := NewUpstreamEmulator()
+	for i := 0; i < 20; i++ {
+		emulator.mu.Lock()
+		challenge := emulator.issueChallenge()
+		emulator.mu.Unlock()
+
+		hash, err := solveVQDChallenge(challenge, challengeUserAgent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		emulator.mu.Lock()
+		accepted := emulator.checkChallenge(hash, challengeUserAgent)
+		emulator.mu.Unlock()
+		if !accepted {
+			t.Fatalf("réponse refusée pour %s", challenge)
+		}
+	}
+}
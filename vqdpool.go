@@ -0,0 +1,186 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Token VQD accompagné de l'identité qui l'a obtenu et du challenge
+// x-vqd-hash-1 délivré avec lui
+type vqdToken struct {
+	Value     string
+	Challenge string
+	Identity  *Identity
+	FetchedAt time.Time
+}
+
+// Réserve de tokens VQD préchargés en arrière-plan: la création de session et
+// les retries n'attendent plus l'endpoint de statut
+type VQDPool struct {
+	mu     sync.Mutex
+	tokens []vqdToken
+	size   int
+	ttl    time.Duration
+	refill chan struct{}
+}
+
+// Pool utilisé par ChatSession (nil = récupération synchrone à chaque besoin)
+var vqdPool *VQDPool
+
+// Démarrage du pool depuis les variables d'environnement:
+// VQD_POOL_SIZE (défaut 4, 0 pour désactiver) et VQD_POOL_TTL (défaut 5m)
+func startVQDPool() {
+	size := 4
+	if value := os.Getenv("VQD_POOL_SIZE"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			log.Fatalf("❌ VQD_POOL_SIZE invalide: %s", value)
+		}
+		size = n
+	}
+	ttl := 5 * time.Minute
+	if value := os.Getenv("VQD_POOL_TTL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Fatalf("❌ VQD_POOL_TTL invalide: %s", value)
+		}
+		ttl = d
+	}
+
+	if size == 0 {
+		log.Printf("🎟️ Pool VQD désactivé")
+		return
+	}
+	vqdPool = NewVQDPool(size, ttl)
+	log.Printf("🎟️ Pool VQD: %d tokens, durée de vie %s", size, ttl)
+}
+
+func NewVQDPool(size int, ttl time.Duration) *VQDPool {
+	p := &VQDPool{
+		size:   size,
+		ttl:    ttl,
+		refill: make(chan struct{}, 1),
+	}
+	go p.run()
+	return p
+}
+
+// Token frais du pool, ou récupéré immédiatement si le pool est vide
+func (p *VQDPool) Get() (vqdToken, bool) {
+	p.mu.Lock()
+	p.dropExpired()
+	var token vqdToken
+	found := len(p.tokens) > 0
+	if found {
+		token = p.tokens[0]
+		p.tokens = p.tokens[1:]
+	}
+	p.mu.Unlock()
+
+	p.wake()
+	if found {
+		return token, true
+	}
+	return fetchVQDToken()
+}
+
+// Nombre de tokens disponibles
+func (p *VQDPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropExpired()
+	return len(p.tokens)
+}
+
+func (p *VQDPool) wake() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// Retrait des tokens expirés ou obtenus par une identité devenue inutilisable
+// (à appeler avec le mutex verrouillé)
+func (p *VQDPool) dropExpired() {
+	fresh := p.tokens[:0]
+	for _, token := range p.tokens {
+		if time.Since(token.FetchedAt) < p.ttl && identities.IsHealthy(token.Identity) {
+			fresh = append(fresh, token)
+		}
+	}
+	p.tokens = fresh
+}
+
+// Boucle de remplissage: à chaque prélèvement et périodiquement pour remplacer
+// les tokens expirés
+func (p *VQDPool) run() {
+	ticker := time.NewTicker(p.ttl / 2)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		if !p.fill() {
+			// Upstream indisponible: on espace les tentatives
+			failures++
+			delay := time.Duration(failures) * 2 * time.Second
+			if delay > time.Minute {
+				delay = time.Minute
+			}
+			time.Sleep(delay)
+			continue
+		}
+		failures = 0
+
+		select {
+		case <-p.refill:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Complétion du pool jusqu'à sa taille; false si un token n'a pas pu être obtenu
+func (p *VQDPool) fill() bool {
+	for {
+		p.mu.Lock()
+		p.dropExpired()
+		missing := p.size - len(p.tokens)
+		p.mu.Unlock()
+		if missing <= 0 {
+			return true
+		}
+
+		token, ok := fetchVQDToken()
+		if !ok {
+			log.Printf("⚠️ Pool VQD: impossible d'obtenir un token")
+			return false
+		}
+
+		p.mu.Lock()
+		p.tokens = append(p.tokens, token)
+		p.mu.Unlock()
+	}
+}
+
+// Récupération d'un token avec l'identité attribuée par le gestionnaire; un échec
+// passe à une autre identité, au plus une fois par proxy
+func fetchVQDToken() (vqdToken, bool) {
+	for i := 0; i < proxies.Len() || i == 0; i++ {
+		identity := identities.Assign()
+		vqd, challenge := fetchVQD(identity)
+		if vqd != "" {
+			return vqdToken{Value: vqd, Challenge: challenge, Identity: identity, FetchedAt: time.Now()}, true
+		}
+	}
+	return vqdToken{}, false
+}
+
+// Token VQD pour une session: depuis le pool s'il est actif
+func acquireVQD() (vqdToken, bool) {
+	if vqdPool != nil {
+		return vqdPool.Get()
+	}
+	return fetchVQDToken()
+}